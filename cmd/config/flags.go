@@ -1,29 +1,49 @@
 package config
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 // -------------------FlagRunAddr--------------------------------
-type FlagRunAddr struct { // host:port for launching the server
-	Host string `env:"SERVER_ADDRESS_HOST"`
-	Port int    `env:"SERVER_ADDRESS_PORT"`
+type FlagRunAddr struct { // host:port or unix:/path/to.sock for launching the server
+	Host   string `env:"SERVER_ADDRESS_HOST"`
+	Port   int    `env:"SERVER_ADDRESS_PORT"`
+	Socket string // Unix domain socket path, instead of the host and port
 }
 
 func (f FlagRunAddr) String() string {
+	if f.Socket != "" {
+		return unixPrefix + f.Socket
+	}
 	return net.JoinHostPort(f.Host, strconv.Itoa(f.Port))
 }
 
+// unixPrefix marks the Unix domain socket address
+const unixPrefix = "unix:"
+
 func (f *FlagRunAddr) Set(s string) error {
 	log.Printf("Setting flag with value: %s", s) // <-- Эта строка
+	if socket, ok := strings.CutPrefix(s, unixPrefix); ok {
+		if socket == "" {
+			return errors.New("the unix socket path is empty")
+		}
+		f.Host, f.Port, f.Socket = "", 0, socket
+		return nil
+	}
 	StrHost, StrPort, err := net.SplitHostPort(s)
 	if err != nil {
 		return err
@@ -33,16 +53,177 @@ func (f *FlagRunAddr) Set(s string) error {
 	if err != nil {
 		return err
 	}
+	f.Socket = ""
 	return nil
 }
 
+// Network is "unix" for the socket and "tcp" for host:port
+func (f FlagRunAddr) Network() string {
+	if f.Socket != "" {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// Address to listen on in the Network
+func (f FlagRunAddr) Address() string {
+	if f.Socket != "" {
+		return f.Socket
+	}
+	return f.String()
+}
+
 // -------------------------------VARIABLES--------------------------------
 var HostFlags FlagRunAddr
-var UrlID string // {id} for shortening url in POST request
+
+// Rate limits per endpoint group, requests per second for a single client (0 - no limit)
+var (
+	RateLimitRedirect float64 // GET /{id}, cheap
+	RateLimitShorten  float64 // POST / and /api/shorten, expensive
+	RateLimitAdmin    float64 // internal endpoints
+)
+
+// Short id generation, random, deterministic (derived from the original url hash)
+// or counter (the sequence number kept by the storage in the alphabet, any length)
+var (
+	ShortIDLength   = 10
+	ShortIDAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
+	ShortIDMode     = "random"
+)
+
+// Sort the query parameters of the original urls, so a=1&b=2 and b=2&a=1 are the same url
+var NormalizeSortQuery bool
+
+// Favicon of the original URL served at GET /{id}/icon
+var (
+	FaviconEnabled bool
+	FaviconTimeout       = 5 * time.Second
+	FaviconMaxSize int64 = 100 << 10 // bytes
+)
+
+// JSON lines file to keep the urls in (empty - memory only)
+var (
+	FileStoragePath       string
+	FileStorageQuarantine bool // move a file with corrupt lines aside on load
+)
+
+// Retention policy: the urls older than the max age (0 - keep all) are moved from the
+// memory or file storage to the archive file (empty - memory), checked every interval
+var (
+	ArchiveMaxAge   time.Duration
+	ArchiveInterval = time.Hour
+	ArchivePath     string
+)
+
+// Expiration of the urls kept in memory only (0 - never), the expired ones
+// aren't found and are purged every sweep interval
+var (
+	URLTTL           time.Duration
+	URLSweepInterval = time.Minute
+)
+
+// How long the answers are replayed to the POST requests repeating an Idempotency-Key (0 - the key is ignored)
+var IdempotencyTTL = 24 * time.Hour
+
+// JSON file {"id": "original url", ...} of the links loaded at the start (empty - none)
+var SeedFile string
+
+// Postgres connection string, takes precedence over the file storage (empty - no database)
+var DatabaseDSN string
+
+// Connection pool of the database (0 - the driver default)
+var (
+	DBMaxOpenConns    = 20
+	DBMaxIdleConns    = 5
+	DBConnMaxLifetime = 30 * time.Minute
+)
+
+// Content-Type of the plain POST / response, set explicitly so it isn't sniffed from the gzipped body
+var PlainContentType = "text/plain; charset=utf-8"
+
+// Largest request body accepted, compressed and decompressed both (0 - no limit)
+var MaxBodySize int64 = 1 << 20 // bytes
+
+// Deadline for handling a single request (0 - no deadline)
+var RequestTimeout = 10 * time.Second
+
+// Body size and deadline of the NDJSON stream lookups (0 - no limit), they replace
+// MaxBodySize and RequestTimeout, and the server timeouts, for the stream
+var (
+	StreamMaxBodySize int64 = 100 << 20 // bytes
+	StreamTimeout           = 5 * time.Minute
+)
+
+// Timeouts of the HTTP server connections (0 - no timeout), the write one must outlive RequestTimeout
+var (
+	ServerReadTimeout  = 10 * time.Second
+	ServerWriteTimeout = 30 * time.Second
+	ServerIdleTimeout  = 120 * time.Second
+)
+
+// Watchdog self-request feeding /livez, every interval (0 - off) answered within the timeout
+var (
+	WatchdogInterval time.Duration
+	WatchdogTimeout  = 5 * time.Second
+)
+
+// CIDR of the clients allowed to the internal endpoints (empty - nobody)
+var TrustedSubnet string
+
+// The server is behind a reverse proxy setting X-Real-IP/X-Forwarded-For, the client
+// address is taken from them, otherwise they are ignored as sent by anyone
+var TrustProxy bool
+
+// host:port of the gRPC server (empty - no gRPC)
+var GRPCAddress string
+
+// Logger mode (development or production) and the minimal level (debug, info, warn, error)
+var (
+	LogMode  = "development"
+	LogLevel = "info"
+)
+
+// Log file rotated by size in megabytes, keeping the number of old files (no file - stderr)
+var (
+	LogFile           string
+	LogFileMaxSize    = 100
+	LogFileMaxBackups = 3
+)
+
+// CORS for the browser clients, comma-separated lists (no origins - CORS is off)
+var (
+	CORSAllowedOrigins string
+	CORSAllowedMethods = "GET, POST, OPTIONS"
+	CORSAllowedHeaders = "Content-Type, Content-Encoding"
+)
+
+// Minimal entropy of the generated ids in bits (0 - no check)
+var MinIDEntropyBits float64
+
+// Skip the per-request access log, e.g. when the router is wrapped in another logging stack
+var NoAccessLog bool
+
+// Smallest response body compressed, the shorter ones gain nothing (0 - compress any)
+var CompressMinSize = 256 // bytes
+
+// Never compress the responses, e.g. when the reverse proxy does it. The gzip requests are still decompressed.
+var NoCompression bool
+
+// Status of the GET /{id} redirect: 301, 302, 307 or 308. The weighted urls
+// pick a random target, so they are always redirected with 307.
+var RedirectStatus = http.StatusTemporaryRedirect
+
+// Landing page the unknown ids are redirected to with 302 (empty - 404)
+var NotFoundRedirectURL string
+
+// Print the build info and exit
+var ShowVersion bool
+
+// the ids must match the GET /{id} route
+var urlSafe = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
 
 // ----------------------------FUNCTIONS------------------------------------
 func ParseFlags() {
-	var envErrHostFlags error
 	var godotenvError error
 
 	// Parse from the env variables first
@@ -50,29 +231,364 @@ func ParseFlags() {
 	if godotenvError != nil {
 		log.Fatalf("godotenv error: %s", godotenvError)
 	}
-	envErrHostFlags = HostFlags.Set(os.Getenv("SERVER_ADDRESS_HOST") + ":" + os.Getenv("SERVER_ADDRESS_PORT"))
-	if envErrHostFlags != nil {
-		log.Fatal("os.Getenv error")
-	}
-	UrlID = os.Getenv("BASE_URL")
 
 	// If no success with env variables then parse from flags
-	flag.Var(&HostFlags, "a", "address and port to run server")
-	flag.Func("b", "shortened URL path", func(s string) error {
-		if !regexp.MustCompile(`[a-zA-Z0-9-]+$`).MatchString(s) {
-			return fmt.Errorf("Invalid URL ID: %s", s)
-		}
-		UrlID = s
+	flag.Var(&HostFlags, "a", "address and port to run server, or unix:/path/to.sock")
+	flag.Func("b", "deprecated and ignored, the ids are generated", func(string) error {
+		log.Println("The -b flag is deprecated and ignored")
 		return nil
 	})
+	flag.Float64Var(&RateLimitRedirect, "rl-redirect", RateLimitRedirect, "redirect requests per second per client (0 - no limit)")
+	flag.Float64Var(&RateLimitShorten, "rl-shorten", RateLimitShorten, "shorten requests per second per client (0 - no limit)")
+	flag.Float64Var(&RateLimitAdmin, "rl-admin", RateLimitAdmin, "admin requests per second per client (0 - no limit)")
+	flag.IntVar(&ShortIDLength, "id-length", ShortIDLength, "length of the generated short ids")
+	flag.StringVar(&ShortIDAlphabet, "id-alphabet", ShortIDAlphabet, "characters of the generated short ids")
+	flag.StringVar(&ShortIDMode, "id-mode", ShortIDMode, "short id mode: random, deterministic or counter")
+	flag.BoolVar(&NormalizeSortQuery, "normalize-sort-query", NormalizeSortQuery, "sort the query parameters of the original urls")
+	flag.BoolVar(&FaviconEnabled, "favicon", FaviconEnabled, "serve the original URL's favicon at /{id}/icon")
+	flag.DurationVar(&FaviconTimeout, "favicon-timeout", FaviconTimeout, "timeout for fetching a favicon")
+	flag.Int64Var(&FaviconMaxSize, "favicon-max-size", FaviconMaxSize, "max favicon size in bytes")
+	flag.StringVar(&FileStoragePath, "f", FileStoragePath, "file storage path")
+	flag.StringVar(&DatabaseDSN, "d", DatabaseDSN, "postgres connection string")
+	flag.StringVar(&SeedFile, "seed-file", SeedFile, "JSON file of the id -> original url links to load at the start")
+	flag.IntVar(&DBMaxOpenConns, "db-max-open-conns", DBMaxOpenConns, "max open database connections (0 - unlimited)")
+	flag.IntVar(&DBMaxIdleConns, "db-max-idle-conns", DBMaxIdleConns, "max idle database connections (0 - driver default)")
+	flag.DurationVar(&DBConnMaxLifetime, "db-conn-max-lifetime", DBConnMaxLifetime, "max lifetime of a database connection (0 - forever)")
+	flag.DurationVar(&ArchiveMaxAge, "archive-max-age", ArchiveMaxAge, "archive the urls older than this (0 - keep all)")
+	flag.DurationVar(&ArchiveInterval, "archive-interval", ArchiveInterval, "interval of the archive checks")
+	flag.StringVar(&ArchivePath, "archive-path", ArchivePath, "archive file path (empty - memory)")
+	flag.DurationVar(&URLTTL, "url-ttl", URLTTL, "expire the urls older than this, memory storage only (0 - never)")
+	flag.DurationVar(&URLSweepInterval, "url-sweep-interval", URLSweepInterval, "interval of purging the expired urls")
+	flag.DurationVar(&IdempotencyTTL, "idempotency-ttl", IdempotencyTTL, "how long the answers are kept by the Idempotency-Key (0 - off)")
+	flag.BoolVar(&FileStorageQuarantine, "f-quarantine", FileStorageQuarantine, "quarantine the file storage having corrupt lines")
+	flag.StringVar(&PlainContentType, "plain-content-type", PlainContentType, "Content-Type of the plain POST response")
+	flag.Int64Var(&MaxBodySize, "max-body-size", MaxBodySize, "max request body size in bytes (0 - no limit)")
+	flag.DurationVar(&RequestTimeout, "request-timeout", RequestTimeout, "timeout for handling a request (0 - no timeout)")
+	flag.Int64Var(&StreamMaxBodySize, "stream-max-body-size", StreamMaxBodySize, "max body size of the stream lookups in bytes (0 - no limit)")
+	flag.DurationVar(&StreamTimeout, "stream-timeout", StreamTimeout, "timeout for the stream lookups (0 - no timeout)")
+	flag.DurationVar(&ServerReadTimeout, "read-timeout", ServerReadTimeout, "timeout for reading a request (0 - no timeout)")
+	flag.DurationVar(&ServerWriteTimeout, "write-timeout", ServerWriteTimeout, "timeout for writing a response (0 - no timeout)")
+	flag.DurationVar(&ServerIdleTimeout, "idle-timeout", ServerIdleTimeout, "timeout for an idle keep-alive connection (0 - no timeout)")
+	flag.DurationVar(&WatchdogInterval, "watchdog-interval", WatchdogInterval, "interval of the liveness self-request (0 - off)")
+	flag.DurationVar(&WatchdogTimeout, "watchdog-timeout", WatchdogTimeout, "timeout of the liveness self-request")
+	flag.StringVar(&TrustedSubnet, "t", TrustedSubnet, "trusted subnet CIDR for the internal endpoints")
+	flag.BoolVar(&TrustProxy, "trust-proxy", TrustProxy, "take the client address from X-Real-IP/X-Forwarded-For of the reverse proxy")
+	flag.StringVar(&GRPCAddress, "grpc-address", GRPCAddress, "host:port of the gRPC server, empty - no gRPC")
+	flag.StringVar(&LogMode, "log-mode", LogMode, "logger mode: development or production")
+	flag.StringVar(&LogLevel, "log-level", LogLevel, "minimal log level: debug, info, warn or error")
+	flag.StringVar(&LogFile, "log-file", LogFile, "file for the logs with rotation instead of stderr")
+	flag.IntVar(&LogFileMaxSize, "log-file-max-size", LogFileMaxSize, "size of the log file in megabytes to rotate it")
+	flag.IntVar(&LogFileMaxBackups, "log-file-max-backups", LogFileMaxBackups, "number of the rotated log files to keep (0 - all)")
+	flag.StringVar(&CORSAllowedOrigins, "cors-origins", CORSAllowedOrigins, "comma-separated CORS origins, * for any")
+	flag.StringVar(&CORSAllowedMethods, "cors-methods", CORSAllowedMethods, "comma-separated CORS methods")
+	flag.StringVar(&CORSAllowedHeaders, "cors-headers", CORSAllowedHeaders, "comma-separated CORS request headers")
+	flag.Float64Var(&MinIDEntropyBits, "min-id-entropy-bits", MinIDEntropyBits, "minimal entropy of the short ids in bits (0 - no check)")
+	flag.BoolVar(&NoAccessLog, "no-access-log", NoAccessLog, "don't log the requests and responses")
+	flag.BoolVar(&NoCompression, "no-compression", NoCompression, "don't compress the responses")
+	flag.IntVar(&CompressMinSize, "compress-min-size", CompressMinSize, "smallest response body compressed, bytes")
+	flag.StringVar(&NotFoundRedirectURL, "not-found-redirect", NotFoundRedirectURL, "landing page for the unknown ids (empty - 404)")
+	flag.IntVar(&RedirectStatus, "redirect-status", RedirectStatus, "status of the redirect: 301, 302, 307 or 308")
+	flag.BoolVar(&ShowVersion, "version", ShowVersion, "print the build info and exit")
+	flag.Parse()
+
+	// The env variables have priority over the flags, but the host and port pair
+	// set by variables.env is only the default of a missing -a
+	host, port := os.Getenv("SERVER_ADDRESS_HOST"), os.Getenv("SERVER_ADDRESS_PORT")
+	if (host != "" || port != "") && !flagSet("a") {
+		if err := HostFlags.Set(host + ":" + port); err != nil {
+			log.Fatal("os.Getenv error")
+		}
+	}
+	if addr := os.Getenv("SERVER_ADDRESS"); addr != "" { // host:port or unix:/path/to.sock
+		if err := HostFlags.Set(addr); err != nil {
+			log.Fatalf("SERVER_ADDRESS: %s", err)
+		}
+	}
+	if os.Getenv("BASE_URL") != "" { // deprecated like -b, kept so the old deployments start
+		log.Println("BASE_URL is deprecated and ignored")
+	}
+	envFloat("RATE_LIMIT_REDIRECT", &RateLimitRedirect)
+	envFloat("RATE_LIMIT_SHORTEN", &RateLimitShorten)
+	envFloat("RATE_LIMIT_ADMIN", &RateLimitAdmin)
+	envInt("SHORT_ID_LENGTH", &ShortIDLength)
+	envString("SHORT_ID_ALPHABET", &ShortIDAlphabet)
+	envString("SHORT_ID_MODE", &ShortIDMode)
+	envBool("NORMALIZE_SORT_QUERY", &NormalizeSortQuery)
+	envBool("FAVICON_ENABLED", &FaviconEnabled)
+	envDuration("FAVICON_TIMEOUT", &FaviconTimeout)
+	envInt64("FAVICON_MAX_SIZE", &FaviconMaxSize)
+	envString("FILE_STORAGE_PATH", &FileStoragePath)
+	envString("DATABASE_DSN", &DatabaseDSN)
+	envString("SEED_FILE", &SeedFile)
+	envInt("DB_MAX_OPEN_CONNS", &DBMaxOpenConns)
+	envInt("DB_MAX_IDLE_CONNS", &DBMaxIdleConns)
+	envDuration("DB_CONN_MAX_LIFETIME", &DBConnMaxLifetime)
+	envDuration("ARCHIVE_MAX_AGE", &ArchiveMaxAge)
+	envDuration("ARCHIVE_INTERVAL", &ArchiveInterval)
+	envString("ARCHIVE_PATH", &ArchivePath)
+	envDuration("URL_TTL", &URLTTL)
+	envDuration("URL_SWEEP_INTERVAL", &URLSweepInterval)
+	envDuration("IDEMPOTENCY_TTL", &IdempotencyTTL)
+	envBool("FILE_STORAGE_QUARANTINE", &FileStorageQuarantine)
+	envString("PLAIN_CONTENT_TYPE", &PlainContentType)
+	envInt64("MAX_BODY_SIZE", &MaxBodySize)
+	envDuration("REQUEST_TIMEOUT", &RequestTimeout)
+	envInt64("STREAM_MAX_BODY_SIZE", &StreamMaxBodySize)
+	envDuration("STREAM_TIMEOUT", &StreamTimeout)
+	envDuration("SERVER_READ_TIMEOUT", &ServerReadTimeout)
+	envDuration("SERVER_WRITE_TIMEOUT", &ServerWriteTimeout)
+	envDuration("SERVER_IDLE_TIMEOUT", &ServerIdleTimeout)
+	envDuration("WATCHDOG_INTERVAL", &WatchdogInterval)
+	envDuration("WATCHDOG_TIMEOUT", &WatchdogTimeout)
+	envString("TRUSTED_SUBNET", &TrustedSubnet)
+	envBool("TRUST_PROXY", &TrustProxy)
+	envString("GRPC_ADDRESS", &GRPCAddress)
+	envString("LOG_MODE", &LogMode)
+	envString("LOG_LEVEL", &LogLevel)
+	envString("LOG_FILE", &LogFile)
+	envInt("LOG_FILE_MAX_SIZE", &LogFileMaxSize)
+	envInt("LOG_FILE_MAX_BACKUPS", &LogFileMaxBackups)
+	envString("CORS_ALLOWED_ORIGINS", &CORSAllowedOrigins)
+	envString("CORS_ALLOWED_METHODS", &CORSAllowedMethods)
+	envString("CORS_ALLOWED_HEADERS", &CORSAllowedHeaders)
+	envFloat("MIN_ID_ENTROPY_BITS", &MinIDEntropyBits)
+	envBool("NO_ACCESS_LOG", &NoAccessLog)
+	envBool("NO_COMPRESSION", &NoCompression)
+	envInt("COMPRESS_MIN_SIZE", &CompressMinSize)
+	envInt("REDIRECT_STATUS", &RedirectStatus)
+	envString("NOT_FOUND_REDIRECT_URL", &NotFoundRedirectURL)
+
+	if HostFlags.Host == "" && HostFlags.Port == 0 && HostFlags.Socket == "" {
+		log.Println("Error parsing host flags: ", HostFlags)
+	}
+	if err := Validate(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// flagSet reports whether the flag is given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Validate checks the parsed values are usable
+func Validate() error {
+	for name, limit := range map[string]float64{
+		"redirect": RateLimitRedirect,
+		"shorten":  RateLimitShorten,
+		"admin":    RateLimitAdmin,
+	} {
+		if limit < 0 {
+			return fmt.Errorf("negative %s rate limit: %v", name, limit)
+		}
+	}
+	if ShortIDLength <= 0 {
+		return fmt.Errorf("short id length must be positive: %d", ShortIDLength)
+	}
+	if ShortIDAlphabet == "" {
+		return errors.New("empty short id alphabet")
+	}
+	if !urlSafe.MatchString(ShortIDAlphabet) {
+		return fmt.Errorf("short id alphabet is not URL-safe: %q", ShortIDAlphabet)
+	}
+	switch ShortIDMode {
+	case "random", "deterministic":
+	case "counter":
+		if len(ShortIDAlphabet) < 2 {
+			return errors.New("counter ids need at least 2 characters in the alphabet")
+		}
+	default:
+		return fmt.Errorf("unknown short id mode: %s", ShortIDMode)
+	}
+	if entropy := IDEntropyBits(ShortIDLength, ShortIDAlphabet); entropy < MinIDEntropyBits {
+		return fmt.Errorf("short ids have %.1f bits of entropy, %.1f required", entropy, MinIDEntropyBits)
+	}
+	if FaviconTimeout <= 0 {
+		return fmt.Errorf("favicon timeout must be positive: %s", FaviconTimeout)
+	}
+	if FaviconMaxSize <= 0 {
+		return fmt.Errorf("favicon max size must be positive: %d", FaviconMaxSize)
+	}
+	if DBMaxOpenConns < 0 || DBMaxIdleConns < 0 {
+		return fmt.Errorf("negative database connections: %d open, %d idle", DBMaxOpenConns, DBMaxIdleConns)
+	}
+	if DBConnMaxLifetime < 0 {
+		return fmt.Errorf("negative database connection lifetime: %s", DBConnMaxLifetime)
+	}
+	if ArchiveMaxAge < 0 {
+		return fmt.Errorf("negative archive max age: %s", ArchiveMaxAge)
+	}
+	if ArchiveInterval <= 0 {
+		return fmt.Errorf("archive interval must be positive: %s", ArchiveInterval)
+	}
+	if ArchiveMaxAge > 0 && DatabaseDSN != "" {
+		return errors.New("the retention policy isn't supported by the database storage")
+	}
+	if URLTTL < 0 {
+		return fmt.Errorf("negative url ttl: %s", URLTTL)
+	}
+	if URLSweepInterval <= 0 {
+		return fmt.Errorf("url sweep interval must be positive: %s", URLSweepInterval)
+	}
+	if URLTTL > 0 && (DatabaseDSN != "" || FileStoragePath != "" || ArchiveMaxAge > 0) {
+		return errors.New("the url ttl is supported by the memory storage only, without the retention policy")
+	}
+	if IdempotencyTTL < 0 {
+		return fmt.Errorf("negative idempotency ttl: %s", IdempotencyTTL)
+	}
+	switch RedirectStatus {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("redirect status must be 301, 302, 307 or 308: %d", RedirectStatus)
+	}
+	if NotFoundRedirectURL != "" {
+		if u, err := url.Parse(NotFoundRedirectURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("not found redirect must be an absolute http(s) url: %s", NotFoundRedirectURL)
+		}
+	}
+	if MaxBodySize < 0 {
+		return fmt.Errorf("negative max body size: %d", MaxBodySize)
+	}
+	if StreamMaxBodySize < 0 {
+		return fmt.Errorf("negative stream max body size: %d", StreamMaxBodySize)
+	}
+	if StreamTimeout < 0 {
+		return fmt.Errorf("negative stream timeout: %s", StreamTimeout)
+	}
+	if CompressMinSize < 0 {
+		return fmt.Errorf("negative compress min size: %d", CompressMinSize)
+	}
+	if RequestTimeout < 0 {
+		return fmt.Errorf("negative request timeout: %s", RequestTimeout)
+	}
+	for name, timeout := range map[string]time.Duration{
+		"read":  ServerReadTimeout,
+		"write": ServerWriteTimeout,
+		"idle":  ServerIdleTimeout,
+	} {
+		if timeout < 0 {
+			return fmt.Errorf("negative server %s timeout: %s", name, timeout)
+		}
+	}
+	if WatchdogInterval < 0 {
+		return fmt.Errorf("negative watchdog interval: %s", WatchdogInterval)
+	}
+	if WatchdogTimeout <= 0 {
+		return fmt.Errorf("watchdog timeout must be positive: %s", WatchdogTimeout)
+	}
+	if GRPCAddress != "" {
+		if _, _, err := net.SplitHostPort(GRPCAddress); err != nil {
+			return fmt.Errorf("grpc address: %w", err)
+		}
+	}
+	if TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(TrustedSubnet); err != nil {
+			return fmt.Errorf("trusted subnet: %w", err)
+		}
+	}
+	if LogMode != "development" && LogMode != "production" {
+		return fmt.Errorf("unknown log mode: %s", LogMode)
+	}
+	switch LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("unknown log level: %s", LogLevel)
+	}
+	if LogFileMaxSize <= 0 {
+		return fmt.Errorf("log file max size must be positive: %d", LogFileMaxSize)
+	}
+	if LogFileMaxBackups < 0 {
+		return fmt.Errorf("negative log file max backups: %d", LogFileMaxBackups)
+	}
+	return nil
+}
+
+// IDEntropyBits is the entropy of a random id: length * log2(distinct characters)
+func IDEntropyBits(length int, alphabet string) float64 {
+	distinct := map[rune]bool{}
+	for _, r := range alphabet {
+		distinct[r] = true
+	}
+	if len(distinct) == 0 {
+		return 0
+	}
+	return float64(length) * math.Log2(float64(len(distinct)))
+}
 
-	if envErrHostFlags != nil || (HostFlags.Host == "" && HostFlags.Port == 0) {
-		log.Println("Error parsing host flags: ", envErrHostFlags)
+// envFloat overwrites dst with the env variable if it is set
+func envFloat(name string, dst *float64) {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		log.Fatalf("%s: %s", name, err)
 	}
-	if UrlID == "" {
-		log.Println("Error parsing url ID: ", UrlID)
+	*dst = v
+}
+
+// envInt overwrites dst with the env variable if it is set
+func envInt(name string, dst *int) {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		log.Fatalf("%s: %s", name, err)
 	}
-	if envErrHostFlags != nil || UrlID == "" {
-		flag.Parse()
+	*dst = v
+}
+
+// envString overwrites dst with the env variable if it is set
+func envString(name string, dst *string) {
+	if s, ok := os.LookupEnv(name); ok {
+		*dst = s
+	}
+}
+
+// envInt64 overwrites dst with the env variable if it is set
+func envInt64(name string, dst *int64) {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		log.Fatalf("%s: %s", name, err)
+	}
+	*dst = v
+}
+
+// envBool overwrites dst with the env variable if it is set
+func envBool(name string, dst *bool) {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		log.Fatalf("%s: %s", name, err)
+	}
+	*dst = v
+}
+
+// envDuration overwrites dst with the env variable if it is set
+func envDuration(name string, dst *time.Duration) {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		log.Fatalf("%s: %s", name, err)
 	}
+	*dst = v
 }
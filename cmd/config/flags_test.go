@@ -0,0 +1,480 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// the defaults to reset the globals between the cases
+var (
+	defaultShortIDLength   = ShortIDLength
+	defaultShortIDAlphabet = ShortIDAlphabet
+	defaultFaviconTimeout  = FaviconTimeout
+	defaultFaviconMaxSize  = FaviconMaxSize
+	defaultRequestTimeout  = RequestTimeout
+	defaultServerTimeouts  = [3]time.Duration{ServerReadTimeout, ServerWriteTimeout, ServerIdleTimeout}
+)
+
+func resetConfig() {
+	RateLimitRedirect, RateLimitShorten, RateLimitAdmin = 0, 0, 0
+	ShortIDLength, ShortIDAlphabet, ShortIDMode = defaultShortIDLength, defaultShortIDAlphabet, "random"
+	FaviconTimeout, FaviconMaxSize = defaultFaviconTimeout, defaultFaviconMaxSize
+	RequestTimeout = defaultRequestTimeout
+	ArchiveMaxAge, ArchiveInterval, DatabaseDSN = 0, time.Hour, ""
+	URLTTL, URLSweepInterval, FileStoragePath = 0, time.Minute, ""
+	IdempotencyTTL = 24 * time.Hour
+	MaxBodySize, CompressMinSize = 1<<20, 256
+	StreamMaxBodySize, StreamTimeout = 100<<20, 5*time.Minute
+	RedirectStatus, NotFoundRedirectURL = 307, ""
+	DBMaxOpenConns, DBMaxIdleConns, DBConnMaxLifetime = 20, 5, 30*time.Minute
+	ServerReadTimeout, ServerWriteTimeout, ServerIdleTimeout = defaultServerTimeouts[0], defaultServerTimeouts[1], defaultServerTimeouts[2]
+	WatchdogInterval, WatchdogTimeout = 0, 5*time.Second
+	TrustedSubnet, TrustProxy, GRPCAddress = "", false, ""
+	LogMode, LogLevel = "development", "info"
+	LogFile, LogFileMaxSize, LogFileMaxBackups = "", 100, 3
+	MinIDEntropyBits = 0
+}
+
+func Test_Validate(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Set     func()
+		WantErr bool
+	}{
+		{
+			Name:    "Defaults",
+			Set:     func() {},
+			WantErr: false,
+		},
+		{
+			Name:    "Positive rate limits",
+			Set:     func() { RateLimitRedirect, RateLimitShorten, RateLimitAdmin = 100, 1, 0.5 },
+			WantErr: false,
+		},
+		{
+			Name:    "Negative rate limit",
+			Set:     func() { RateLimitShorten = -1 },
+			WantErr: true,
+		},
+		{
+			Name:    "Custom short id",
+			Set:     func() { ShortIDLength, ShortIDAlphabet = 4, "abc-123" },
+			WantErr: false,
+		},
+		{
+			Name:    "Zero short id length",
+			Set:     func() { ShortIDLength = 0 },
+			WantErr: true,
+		},
+		{
+			Name:    "Empty alphabet",
+			Set:     func() { ShortIDAlphabet = "" },
+			WantErr: true,
+		},
+		{
+			Name:    "Not URL-safe alphabet",
+			Set:     func() { ShortIDAlphabet = "ab/?#" },
+			WantErr: true,
+		},
+		{
+			Name:    "Deterministic ids",
+			Set:     func() { ShortIDMode = "deterministic" },
+			WantErr: false,
+		},
+		{
+			Name:    "Counter ids",
+			Set:     func() { ShortIDMode = "counter" },
+			WantErr: false,
+		},
+		{
+			Name:    "Counter ids of a single character",
+			Set:     func() { ShortIDMode, ShortIDAlphabet = "counter", "a" },
+			WantErr: true,
+		},
+		{
+			Name:    "Unknown id mode",
+			Set:     func() { ShortIDMode = "sequential" },
+			WantErr: true,
+		},
+		{
+			Name:    "Zero favicon timeout",
+			Set:     func() { FaviconTimeout = 0 },
+			WantErr: true,
+		},
+		{
+			Name:    "Negative favicon size",
+			Set:     func() { FaviconMaxSize = -1 },
+			WantErr: true,
+		},
+		{
+			Name:    "Unlimited database pool",
+			Set:     func() { DBMaxOpenConns, DBMaxIdleConns, DBConnMaxLifetime = 0, 0, 0 },
+			WantErr: false,
+		},
+		{
+			Name:    "Negative open connections",
+			Set:     func() { DBMaxOpenConns = -1 },
+			WantErr: true,
+		},
+		{
+			Name:    "Negative connection lifetime",
+			Set:     func() { DBConnMaxLifetime = -time.Minute },
+			WantErr: true,
+		},
+		{
+			Name:    "Retention policy",
+			Set:     func() { ArchiveMaxAge, ArchiveInterval = 30*24*time.Hour, time.Minute },
+			WantErr: false,
+		},
+		{
+			Name:    "Negative archive max age",
+			Set:     func() { ArchiveMaxAge = -time.Hour },
+			WantErr: true,
+		},
+		{
+			Name:    "Zero archive interval",
+			Set:     func() { ArchiveInterval = 0 },
+			WantErr: true,
+		},
+		{
+			Name:    "Retention with the database",
+			Set:     func() { ArchiveMaxAge, DatabaseDSN = time.Hour, "postgres://localhost/urls" },
+			WantErr: true,
+		},
+		{
+			Name:    "Url ttl",
+			Set:     func() { URLTTL, URLSweepInterval = 24*time.Hour, time.Second },
+			WantErr: false,
+		},
+		{
+			Name:    "Negative url ttl",
+			Set:     func() { URLTTL = -time.Hour },
+			WantErr: true,
+		},
+		{
+			Name:    "Zero url sweep interval",
+			Set:     func() { URLSweepInterval = 0 },
+			WantErr: true,
+		},
+		{
+			Name:    "Url ttl with the file storage",
+			Set:     func() { URLTTL, FileStoragePath = time.Hour, "/tmp/urls.json" },
+			WantErr: true,
+		},
+		{
+			Name:    "Url ttl with the database",
+			Set:     func() { URLTTL, DatabaseDSN = time.Hour, "postgres://localhost/urls" },
+			WantErr: true,
+		},
+		{
+			Name:    "Url ttl with the retention policy",
+			Set:     func() { URLTTL, ArchiveMaxAge = time.Hour, 30*24*time.Hour },
+			WantErr: true,
+		},
+		{
+			Name:    "No idempotency",
+			Set:     func() { IdempotencyTTL = 0 },
+			WantErr: false,
+		},
+		{
+			Name:    "Negative idempotency ttl",
+			Set:     func() { IdempotencyTTL = -time.Hour },
+			WantErr: true,
+		},
+		{
+			Name:    "Permanent redirect",
+			Set:     func() { RedirectStatus = 301 },
+			WantErr: false,
+		},
+		{
+			Name:    "Not a redirect status",
+			Set:     func() { RedirectStatus = 200 },
+			WantErr: true,
+		},
+		{
+			Name:    "Not found landing page",
+			Set:     func() { NotFoundRedirectURL = "https://practicum.net/landing" },
+			WantErr: false,
+		},
+		{
+			Name:    "Relative not found landing page",
+			Set:     func() { NotFoundRedirectURL = "/landing" },
+			WantErr: true,
+		},
+		{
+			Name:    "No body limit",
+			Set:     func() { MaxBodySize = 0 },
+			WantErr: false,
+		},
+		{
+			Name:    "Negative max body size",
+			Set:     func() { MaxBodySize = -1 },
+			WantErr: true,
+		},
+		{
+			Name:    "Negative stream max body size",
+			Set:     func() { StreamMaxBodySize = -1 },
+			WantErr: true,
+		},
+		{
+			Name:    "Negative stream timeout",
+			Set:     func() { StreamTimeout = -time.Second },
+			WantErr: true,
+		},
+		{
+			Name:    "Compress any body",
+			Set:     func() { CompressMinSize = 0 },
+			WantErr: false,
+		},
+		{
+			Name:    "Negative compress min size",
+			Set:     func() { CompressMinSize = -1 },
+			WantErr: true,
+		},
+		{
+			Name:    "No request timeout",
+			Set:     func() { RequestTimeout = 0 },
+			WantErr: false,
+		},
+		{
+			Name:    "Negative request timeout",
+			Set:     func() { RequestTimeout = -time.Second },
+			WantErr: true,
+		},
+		{
+			Name:    "No server timeouts",
+			Set:     func() { ServerReadTimeout, ServerWriteTimeout, ServerIdleTimeout = 0, 0, 0 },
+			WantErr: false,
+		},
+		{
+			Name:    "Negative read timeout",
+			Set:     func() { ServerReadTimeout = -time.Second },
+			WantErr: true,
+		},
+		{
+			Name:    "Negative write timeout",
+			Set:     func() { ServerWriteTimeout = -time.Second },
+			WantErr: true,
+		},
+		{
+			Name:    "Negative idle timeout",
+			Set:     func() { ServerIdleTimeout = -time.Minute },
+			WantErr: true,
+		},
+		{
+			Name:    "Watchdog",
+			Set:     func() { WatchdogInterval, WatchdogTimeout = 10*time.Second, time.Second },
+			WantErr: false,
+		},
+		{
+			Name:    "Negative watchdog interval",
+			Set:     func() { WatchdogInterval = -time.Second },
+			WantErr: true,
+		},
+		{
+			Name:    "Zero watchdog timeout",
+			Set:     func() { WatchdogTimeout = 0 },
+			WantErr: true,
+		},
+		{
+			Name:    "Trusted subnet",
+			Set:     func() { TrustedSubnet = "192.168.0.0/24" },
+			WantErr: false,
+		},
+		{
+			Name:    "Invalid trusted subnet",
+			Set:     func() { TrustedSubnet = "192.168.0.0" },
+			WantErr: true,
+		},
+		{
+			Name:    "gRPC address",
+			Set:     func() { GRPCAddress = ":3200" },
+			WantErr: false,
+		},
+		{
+			Name:    "gRPC address without port",
+			Set:     func() { GRPCAddress = "localhost" },
+			WantErr: true,
+		},
+		{
+			Name:    "Production warn logger",
+			Set:     func() { LogMode, LogLevel = "production", "warn" },
+			WantErr: false,
+		},
+		{
+			Name:    "Unknown log mode",
+			Set:     func() { LogMode = "verbose" },
+			WantErr: true,
+		},
+		{
+			Name:    "Unknown log level",
+			Set:     func() { LogLevel = "trace" },
+			WantErr: true,
+		},
+		{
+			Name:    "Log file keeping all backups",
+			Set:     func() { LogFile, LogFileMaxSize, LogFileMaxBackups = "/var/log/shortener.log", 10, 0 },
+			WantErr: false,
+		},
+		{
+			Name:    "Zero log file size",
+			Set:     func() { LogFileMaxSize = 0 },
+			WantErr: true,
+		},
+		{
+			Name:    "Negative log file backups",
+			Set:     func() { LogFileMaxBackups = -1 },
+			WantErr: true,
+		},
+		{
+			Name:    "Enough entropy",
+			Set:     func() { MinIDEntropyBits = 59 }, // 10 chars of 62
+			WantErr: false,
+		},
+		{
+			Name:    "Under-entropy ids",
+			Set:     func() { ShortIDLength, ShortIDAlphabet, MinIDEntropyBits = 4, "ab", 32 },
+			WantErr: true,
+		},
+		{
+			Name:    "Repeated characters add no entropy",
+			Set:     func() { ShortIDLength, ShortIDAlphabet, MinIDEntropyBits = 8, "aaaaaaab", 9 },
+			WantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			resetConfig()
+			defer resetConfig()
+			tc.Set()
+			err := Validate()
+			if tc.WantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_IDEntropyBits(t *testing.T) {
+	require.InDelta(t, 59.54, IDEntropyBits(10, defaultShortIDAlphabet), 0.01)
+	require.Equal(t, 4.0, IDEntropyBits(4, "ab"))
+	require.Equal(t, 0.0, IDEntropyBits(4, ""))
+}
+
+func Test_ServerTimeoutsEnv(t *testing.T) {
+	defer resetConfig()
+	t.Setenv("SERVER_READ_TIMEOUT", "5s")
+	t.Setenv("SERVER_WRITE_TIMEOUT", "1m30s")
+	t.Setenv("SERVER_IDLE_TIMEOUT", "-1s")
+
+	envDuration("SERVER_READ_TIMEOUT", &ServerReadTimeout)
+	envDuration("SERVER_WRITE_TIMEOUT", &ServerWriteTimeout)
+	envDuration("SERVER_IDLE_TIMEOUT", &ServerIdleTimeout)
+	require.Equal(t, 5*time.Second, ServerReadTimeout)
+	require.Equal(t, 90*time.Second, ServerWriteTimeout)
+	require.Equal(t, -time.Second, ServerIdleTimeout)
+	require.Error(t, Validate()) // the negative idle timeout
+
+	// unset variables keep the defaults
+	resetConfig()
+	envDuration("SERVER_UNSET_TIMEOUT", &ServerReadTimeout)
+	require.Equal(t, defaultServerTimeouts[0], ServerReadTimeout)
+}
+
+func Test_DBPoolEnv(t *testing.T) {
+	defer resetConfig()
+	t.Setenv("DB_MAX_OPEN_CONNS", "50")
+	t.Setenv("DB_MAX_IDLE_CONNS", "10")
+	t.Setenv("DB_CONN_MAX_LIFETIME", "5m")
+
+	envInt("DB_MAX_OPEN_CONNS", &DBMaxOpenConns)
+	envInt("DB_MAX_IDLE_CONNS", &DBMaxIdleConns)
+	envDuration("DB_CONN_MAX_LIFETIME", &DBConnMaxLifetime)
+	require.Equal(t, 50, DBMaxOpenConns)
+	require.Equal(t, 10, DBMaxIdleConns)
+	require.Equal(t, 5*time.Minute, DBConnMaxLifetime)
+	require.NoError(t, Validate())
+}
+
+func Test_FlagRunAddr(t *testing.T) {
+	tests := []struct {
+		Value       string
+		WantErr     bool
+		WantNetwork string
+		WantAddress string
+	}{
+		{Value: "localhost:8080", WantNetwork: "tcp", WantAddress: "localhost:8080"},
+		{Value: ":8080", WantNetwork: "tcp", WantAddress: ":8080"},
+		{Value: "unix:/var/run/shortener.sock", WantNetwork: "unix", WantAddress: "/var/run/shortener.sock"},
+		{Value: "unix:", WantErr: true},
+		{Value: "localhost", WantErr: true},
+		{Value: "localhost:http", WantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Value, func(t *testing.T) {
+			addr := FlagRunAddr{Host: "old", Port: 1}
+			err := addr.Set(tc.Value)
+			if tc.WantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.WantNetwork, addr.Network())
+			require.Equal(t, tc.WantAddress, addr.Address())
+			require.Equal(t, tc.Value, addr.String())
+		})
+	}
+}
+
+// The -a flag isn't overridden by the host and port of variables.env
+func Test_ParseFlagsAddress(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Args     []string
+		Env      string // SERVER_ADDRESS
+		WantAddr string
+	}{
+		{Name: "Flag", Args: []string{"-a", "unix:/tmp/shortener.sock"}, WantAddr: "unix:/tmp/shortener.sock"},
+		{Name: "No flag", WantAddr: "localhost:8080"},
+		{Name: "Deprecated -b", Args: []string{"-b", "hash", "-a", "unix:/tmp/shortener.sock"}, WantAddr: "unix:/tmp/shortener.sock"},
+		{Name: "SERVER_ADDRESS", Args: []string{"-a", "unix:/tmp/shortener.sock"}, Env: "127.0.0.1:9090", WantAddr: "127.0.0.1:9090"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			oldHostFlags, oldArgs, oldCommandLine := HostFlags, os.Args, flag.CommandLine
+			defer func() {
+				HostFlags, os.Args, flag.CommandLine = oldHostFlags, oldArgs, oldCommandLine
+				resetConfig()
+			}()
+
+			// ParseFlags loads variables.env of the working directory
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "variables.env"), []byte("SERVER_ADDRESS_HOST=localhost\nSERVER_ADDRESS_PORT=8080\n"), 0644))
+			wd, err := os.Getwd()
+			require.NoError(t, err)
+			require.NoError(t, os.Chdir(dir))
+			defer os.Chdir(wd)
+
+			// unset, so godotenv sets them, and restored after the test
+			for _, key := range []string{"SERVER_ADDRESS_HOST", "SERVER_ADDRESS_PORT", "SERVER_ADDRESS"} {
+				t.Setenv(key, "")
+				os.Unsetenv(key)
+			}
+			if tc.Env != "" {
+				t.Setenv("SERVER_ADDRESS", tc.Env)
+			}
+
+			os.Args = append([]string{"shortener"}, tc.Args...)
+			flag.CommandLine = flag.NewFlagSet("shortener", flag.ContinueOnError)
+			ParseFlags()
+			require.Equal(t, tc.WantAddr, HostFlags.String())
+		})
+	}
+}
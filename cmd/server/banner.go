@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Banner is the maintenance notice sent with every redirect
+type Banner struct {
+	mu     sync.RWMutex
+	notice string
+}
+
+func (b *Banner) Get() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.notice
+}
+
+func (b *Banner) Set(notice string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.notice = notice
+}
+
+// SetBannerHandler sets the notice from the plain text body, an empty body clears it
+func (c *Connection) SetBannerHandler(res http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeBodyError(res, err, "Invalid banner")
+		return
+	}
+	notice := strings.TrimSpace(string(body))
+	if strings.ContainsAny(notice, "\r\n") { // must fit in a header
+		writeJSONError(res, http.StatusBadRequest, "Banner must be a single line")
+		return
+	}
+	c.banner.Set(notice)
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// ClearBannerHandler removes the notice
+func (c *Connection) ClearBannerHandler(res http.ResponseWriter, req *http.Request) {
+	c.banner.Set("")
+	res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Banner(t *testing.T) {
+	oldSubnet := config.TrustedSubnet
+	config.TrustedSubnet = "127.0.0.0/8"
+	defer func() { config.TrustedSubnet = oldSubnet }()
+
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+	defer ts.Close()
+
+	tests := []struct {
+		Name       string
+		Method     string
+		Body       string
+		WantCode   int
+		WantNotice string // on the following redirect
+	}{
+		{
+			Name:       "Set",
+			Method:     http.MethodPut,
+			Body:       "Maintenance at 03:00 UTC\n",
+			WantCode:   http.StatusNoContent,
+			WantNotice: "Maintenance at 03:00 UTC",
+		},
+		{
+			Name:       "Multiline rejected",
+			Method:     http.MethodPut,
+			Body:       "first\nsecond",
+			WantCode:   http.StatusBadRequest,
+			WantNotice: "Maintenance at 03:00 UTC",
+		},
+		{
+			Name:       "Cleared",
+			Method:     http.MethodDelete,
+			WantCode:   http.StatusNoContent,
+			WantNotice: "",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp := testRequest(testRequestOptions{
+				t:      t,
+				ts:     ts,
+				method: tc.Method,
+				path:   "/api/internal/banner",
+				body:   bytes.NewBufferString(tc.Body),
+			})
+			resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+
+			resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/sharaga"})
+			resp.Body.Close()
+			require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+			require.Equal(t, tc.WantNotice, resp.Header.Get("X-Shortener-Notice"))
+			_, present := resp.Header["X-Shortener-Notice"]
+			require.Equal(t, tc.WantNotice != "", present)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/models"
+	"github.com/absurd678/skill/internal/storage"
+)
+
+// batchIDs finds the short url of every original url: the shortened ones keep
+// theirs, the new ones get fresh ids returned as the records to save
+func (c *Connection) batchIDs(ctx context.Context, batch []models.BatchURL) (map[string]string, []models.URLRecord, error) {
+	ids := make(map[string]string, len(batch)) // original url -> short url
+	var records []models.URLRecord
+	taken := map[string]bool{}
+	for _, item := range batch {
+		if _, ok := ids[item.OriginalURL]; ok {
+			continue // repeated in the batch
+		}
+		if id, ok := c.store.GetByOriginal(ctx, item.OriginalURL); ok {
+			ids[item.OriginalURL] = id
+			continue
+		}
+		id, err := c.batchID(ctx, item.OriginalURL, taken)
+		if err != nil {
+			return nil, nil, err
+		}
+		taken[id] = true
+		ids[item.OriginalURL] = id
+		records = append(records, models.URLRecord{ShortURL: id, OriginalURL: item.OriginalURL})
+	}
+	return ids, records, nil
+}
+
+// batchID is the id the single shorten would give the original: its hash in the
+// deterministic mode, a unique one otherwise. The ids of the batch aren't saved yet.
+func (c *Connection) batchID(ctx context.Context, original string, taken map[string]bool) (string, error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		var id string
+		if config.ShortIDMode == "deterministic" {
+			if id = hashID(original, i); c.store.Exists(ctx, id) {
+				continue
+			}
+		} else {
+			var err error
+			if id, err = c.uniqueID(ctx); err != nil {
+				return "", err
+			}
+		}
+		if !taken[id] {
+			return id, ctx.Err()
+		}
+	}
+	return "", errIDsExhausted
+}
+
+// shortenBatch saves the new urls of the batch, a concurrent shorten taking one
+// of their ids or originals meanwhile makes the batch looked up again
+func (c *Connection) shortenBatch(ctx context.Context, batch []models.BatchURL) (map[string]string, error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		ids, records, err := c.batchIDs(ctx, batch)
+		if err == nil && len(records) > 0 {
+			err = c.store.SaveBatch(ctx, records)
+		}
+		if !errors.Is(err, storage.ErrIDTaken) && !errors.Is(err, storage.ErrOriginalTaken) {
+			return ids, err
+		}
+	}
+	return nil, errIDsExhausted
+}
+
+// PostHandlerBatch shortens [{"correlation_id": "...", "original_url": "..."}, ...]
+// and answers [{"correlation_id": "...", "short_url": "..."}, ...], the new urls are saved all or none
+func (c *Connection) PostHandlerBatch(res http.ResponseWriter, req *http.Request) {
+	var batch []models.BatchURL
+	if err := decodeJSON(req.Body, &batch); err != nil {
+		writeBodyError(res, err, "Invalid JSON")
+		return
+	}
+	if len(batch) == 0 {
+		writeJSONError(res, http.StatusBadRequest, "Empty batch")
+		return
+	}
+	for i, item := range batch {
+		if item.OriginalURL == "" {
+			writeJSONError(res, http.StatusBadRequest, "Every item needs an original_url")
+			return
+		}
+		if !validURL(item.OriginalURL) {
+			writeJSONError(res, http.StatusBadRequest, "Every original_url must be an absolute http(s) URL")
+			return
+		}
+		batch[i].OriginalURL = normalizeURL(item.OriginalURL)
+	}
+
+	ids, err := c.shortenBatch(req.Context(), batch)
+	if contextDone(res, req) {
+		return
+	}
+	if err != nil {
+		writeShortenError(res, err)
+		return
+	}
+
+	shortURLs := make([]models.BatchShortURL, 0, len(batch))
+	for _, item := range batch {
+		shortURLs = append(shortURLs, models.BatchShortURL{CorrelationID: item.CorrelationID, ShortURL: ids[item.OriginalURL]})
+	}
+	buff, err := json.MarshalIndent(shortURLs, "", " ")
+	if err != nil {
+		writeJSONError(res, http.StatusBadRequest, "Unmarshable data")
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusCreated)
+	res.Write(buff)
+}
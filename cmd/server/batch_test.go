@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/models"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PostHandlerBatch(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Body     string
+		IDs      []string // ids returned by the generator one by one
+		WantCode int
+		WantURLs []models.BatchShortURL
+	}{
+		{
+			Name:     "New and shortened",
+			Body:     `[{"correlation_id": "1", "original_url": "https://practicum.net"}, {"correlation_id": "2", "original_url": "https://mai.ru"}, {"correlation_id": "3", "original_url": "https://ilovespb.ru"}]`,
+			IDs:      []string{"first", "second"},
+			WantCode: http.StatusCreated,
+			WantURLs: []models.BatchShortURL{{CorrelationID: "1", ShortURL: "first"}, {CorrelationID: "2", ShortURL: "sharaga"}, {CorrelationID: "3", ShortURL: "second"}},
+		},
+		{
+			Name:     "Repeated original",
+			Body:     `[{"correlation_id": "1", "original_url": "https://practicum.net"}, {"correlation_id": "2", "original_url": "https://practicum.net"}]`,
+			IDs:      []string{"first"},
+			WantCode: http.StatusCreated,
+			WantURLs: []models.BatchShortURL{{CorrelationID: "1", ShortURL: "first"}, {CorrelationID: "2", ShortURL: "first"}},
+		},
+		{
+			Name:     "Generator repeats itself",
+			Body:     `[{"correlation_id": "1", "original_url": "https://practicum.net"}, {"correlation_id": "2", "original_url": "https://ilovespb.ru"}]`,
+			IDs:      []string{"first"},
+			WantCode: http.StatusInternalServerError,
+		},
+		{
+			Name:     "Empty batch",
+			Body:     `[]`,
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "No original url",
+			Body:     `[{"correlation_id": "1"}]`,
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "Invalid url",
+			Body:     `[{"correlation_id": "1", "original_url": "https://practicum.net"}, {"correlation_id": "2", "original_url": "mai.ru"}]`,
+			IDs:      []string{"first"},
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "Invalid JSON",
+			Body:     `{"correlation_id": "1"}`,
+			WantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			calls := 0
+			oldShortID := newShortID
+			newShortID = func() string {
+				id := tc.IDs[min(calls, len(tc.IDs)-1)]
+				calls++
+				return id
+			}
+			defer func() { newShortID = oldShortID }()
+
+			store := storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+			defer ts.Close()
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/api/shorten/batch", body: bytes.NewBufferString(tc.Body)})
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			if tc.WantCode != http.StatusCreated {
+				stats, err := store.Stats(context.Background())
+				require.NoError(t, err)
+				require.Equal(t, 1, stats.URLs) // nothing saved
+				return
+			}
+			var shortURLs []models.BatchShortURL
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&shortURLs))
+			require.Equal(t, tc.WantURLs, shortURLs)
+			for _, shortURL := range shortURLs {
+				require.True(t, store.Exists(context.Background(), shortURL.ShortURL))
+			}
+		})
+	}
+}
+
+// The batch gets the hashed ids like the single shorten
+func Test_PostHandlerBatchDeterministic(t *testing.T) {
+	oldMode := config.ShortIDMode
+	config.ShortIDMode = "deterministic"
+	defer func() { config.ShortIDMode = oldMode }()
+
+	// the first hash of practicum.net is taken by another url
+	store := storage.NewMemStorage(map[string]string{hashID("https://practicum.net", 0): "https://mai.ru"})
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+	defer ts.Close()
+
+	body := `[{"correlation_id": "1", "original_url": "https://practicum.net"}, {"correlation_id": "2", "original_url": "HTTPS://ilovespb.ru/"}]`
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/api/shorten/batch", body: bytes.NewBufferString(body)})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var shortURLs []models.BatchShortURL
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&shortURLs))
+	require.Equal(t, []models.BatchShortURL{
+		{CorrelationID: "1", ShortURL: hashID("https://practicum.net", 1)},
+		{CorrelationID: "2", ShortURL: hashID("https://ilovespb.ru", 0)}, // normalized
+	}, shortURLs)
+}
+
+// racingStore shortens the first original of the batch right before the batch is saved
+type racingStore struct {
+	*storage.MemStorage
+	raced bool
+}
+
+func (rs *racingStore) SaveBatch(ctx context.Context, records []models.URLRecord) error {
+	if !rs.raced {
+		rs.raced = true
+		if _, _, err := rs.MemStorage.GetOrCreate(ctx, "raced", records[0].OriginalURL); err != nil {
+			return err
+		}
+	}
+	return rs.MemStorage.SaveBatch(ctx, records)
+}
+
+// The original shortened concurrently keeps the id it got
+func Test_PostHandlerBatchRace(t *testing.T) {
+	oldShortID := newShortID
+	ids := []string{"first", "second", "third"}
+	newShortID = func() string {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+	defer func() { newShortID = oldShortID }()
+
+	store := &racingStore{MemStorage: storage.NewMemStorage(nil)}
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+	defer ts.Close()
+
+	body := `[{"correlation_id": "1", "original_url": "https://practicum.net"}, {"correlation_id": "2", "original_url": "https://mai.ru"}]`
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/api/shorten/batch", body: bytes.NewBufferString(body)})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var shortURLs []models.BatchShortURL
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&shortURLs))
+	require.Equal(t, []models.BatchShortURL{{CorrelationID: "1", ShortURL: "raced"}, {CorrelationID: "2", ShortURL: "third"}}, shortURLs)
+}
+
+// The batch endpoints read gzipped bodies and answer gzipped
+func Test_BatchGzip(t *testing.T) {
+	setCompressMinSize(t, 0) // the short answers are compressed too
+
+	oldShortID := newShortID
+	newShortID = func() string { return "first" }
+	defer func() { newShortID = oldShortID }()
+
+	tests := []struct {
+		Name     string
+		Path     string
+		Body     string
+		WantCode int
+		WantBody string
+	}{
+		{
+			Name:     "Shorten",
+			Path:     "/api/shorten/batch",
+			Body:     `[{"correlation_id": "1", "original_url": "https://practicum.net"}, {"correlation_id": "2", "original_url": "https://mai.ru"}]`,
+			WantCode: http.StatusCreated,
+			WantBody: `[{"correlation_id": "1", "short_url": "first"}, {"correlation_id": "2", "short_url": "sharaga"}]`,
+		},
+		{
+			Name:     "Resolve",
+			Path:     "/api/resolve/batch",
+			Body:     `["sharaga", "test"]`,
+			WantCode: http.StatusOK,
+			WantBody: `[{"id": "sharaga", "original_url": "https://mai.ru", "found": true}, {"id": "test", "original_url": "", "found": false}]`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+			defer ts.Close()
+
+			gzipped := bytes.NewBuffer(nil)
+			writer := gzip.NewWriter(gzipped)
+			_, err := writer.Write([]byte(tc.Body))
+			require.NoError(t, err)
+			require.NoError(t, writer.Close())
+
+			// the transport must not decompress the answer itself
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			req, err := http.NewRequest(http.MethodPost, ts.URL+tc.Path, gzipped)
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", "gzip")
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+			reader, err := gzip.NewReader(resp.Body)
+			require.NoError(t, err)
+			body, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.WantBody, string(body))
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/absurd678/skill/internal/storage"
+)
+
+// benchStored is the count of urls a router stores before the benchmark
+// replaces it, so the runs with growing b.N measure the same store size
+const benchStored = 1000
+
+// POST / then GET /{id} through the router against the memory store,
+// every iteration shortens a new url
+func BenchmarkRoundTrip(b *testing.B) {
+	router := LaunchMyRouter(NewConnection(storage.NewMemStorage(nil)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%benchStored == 0 && i > 0 {
+			b.StopTimer()
+			router = LaunchMyRouter(NewConnection(storage.NewMemStorage(nil)))
+			b.StartTimer()
+		}
+		post := httptest.NewRecorder()
+		original := "https://practicum.net/" + strconv.Itoa(i%benchStored)
+		router.ServeHTTP(post, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(original)))
+		if post.Code != http.StatusCreated {
+			b.Fatalf("POST: %d", post.Code)
+		}
+
+		get := httptest.NewRecorder()
+		router.ServeHTTP(get, httptest.NewRequest(http.MethodGet, post.Body.String(), nil))
+		if get.Code != http.StatusTemporaryRedirect {
+			b.Fatalf("GET: %d", get.Code)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const maxAcceptEncodingLen = 256 // longer headers are not parsed at all
+
+// acceptEncodings parses "gzip;q=0.8, br, *;q=0" into coding -> quality,
+// the codings without q have quality 1 and the malformed ones are skipped
+func acceptEncodings(acceptEncoding string) map[string]float64 {
+	codings := map[string]float64{}
+	if len(acceptEncoding) > maxAcceptEncodingLen {
+		return codings
+	}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		quality := 1.0
+		if params = strings.TrimSpace(params); params != "" {
+			name, value, ok := strings.Cut(params, "=")
+			if !ok || strings.TrimSpace(name) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+			quality = q
+		}
+		codings[coding] = quality
+	}
+	return codings
+}
+
+// compressors create the response encoders by the Content-Encoding,
+// the HTTP deflate is the zlib format
+var compressors = map[string]func(w io.Writer) (io.WriteCloser, error){
+	"gzip": func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, gzip.BestSpeed)
+	},
+	"br": func(w io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriterLevel(w, brotli.BestSpeed), nil
+	},
+	"deflate": func(w io.Writer) (io.WriteCloser, error) {
+		return zlib.NewWriterLevel(w, zlib.BestSpeed)
+	},
+}
+
+// codingPreference breaks the quality ties, gzip stays the fallback
+var codingPreference = []string{"gzip", "br", "deflate"}
+
+// chooseEncoding picks the accepted coding with the highest quality, named or
+// by *, x-gzip is gzip. "" means no compression.
+func chooseEncoding(acceptEncoding string) string {
+	codings := acceptEncodings(acceptEncoding)
+	if q, ok := codings["x-gzip"]; ok {
+		if _, named := codings["gzip"]; !named {
+			codings["gzip"] = q
+		}
+	}
+	best, bestQuality := "", 0.0
+	for _, coding := range codingPreference {
+		quality, ok := codings[coding]
+		if !ok {
+			quality = codings["*"]
+		}
+		if quality > bestQuality {
+			best, bestQuality = coding, quality
+		}
+	}
+	return best
+}
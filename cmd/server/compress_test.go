@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/require"
+)
+
+// setCompressMinSize sets the config min size for the test and restores it after
+func setCompressMinSize(t *testing.T, size int) {
+	oldSize := config.CompressMinSize
+	config.CompressMinSize = size
+	t.Cleanup(func() { config.CompressMinSize = oldSize })
+}
+
+// A huge Accept-Encoding is not parsed and the answer is not compressed
+func Test_OversizedAcceptEncoding(t *testing.T) {
+	setCompressMinSize(t, 0) // the short answers are compressed too
+
+	tests := []struct {
+		Name         string
+		Encoding     string
+		WantEncoding string
+	}{
+		{
+			Name:         "Normal",
+			Encoding:     "deflate, gzip",
+			WantEncoding: "gzip",
+		},
+		{
+			Name:         "Oversized",
+			Encoding:     strings.Repeat("x-unknown;q=0.1, ", 4096) + "gzip",
+			WantEncoding: "",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/", bytes.NewBufferString("https://practicum.net"))
+			require.NoError(t, err)
+			req.Header.Set("Accept-Encoding", tc.Encoding)
+
+			start := time.Now()
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Less(t, time.Since(start), time.Second)
+
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			require.Equal(t, tc.WantEncoding, resp.Header.Get("Content-Encoding"))
+			if tc.WantEncoding == "" {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.True(t, strings.HasPrefix(string(body), "/"))
+			}
+		})
+	}
+}
+
+// The compressible answers tell the caches they depend on Accept-Encoding
+func Test_VaryAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Encoding string
+	}{
+		{Name: "Compressed", Encoding: "gzip"},
+		{Name: "Identity", Encoding: "identity"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/shorten", bytes.NewBufferString(`{"url": "https://practicum.net"}`))
+			require.NoError(t, err)
+			req.Header.Set("Accept-Encoding", tc.Encoding)
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			require.Equal(t, []string{"Accept-Encoding"}, resp.Header.Values("Vary"))
+		})
+	}
+}
+
+func Test_ChooseEncoding(t *testing.T) {
+	tests := []struct {
+		Name           string
+		AcceptEncoding string
+		Want           string
+	}{
+		{Name: "gzip", AcceptEncoding: "gzip", Want: "gzip"},
+		{Name: "x-gzip", AcceptEncoding: "x-gzip", Want: "gzip"},
+		{Name: "br", AcceptEncoding: "br", Want: "br"},
+		{Name: "deflate", AcceptEncoding: "deflate", Want: "deflate"},
+		{Name: "gzip with q", AcceptEncoding: "deflate;q=0.4, gzip;q=0.5", Want: "gzip"},
+		{Name: "br preferred", AcceptEncoding: "gzip;q=0.8, br", Want: "br"},
+		{Name: "equal qualities fall back to gzip", AcceptEncoding: "deflate, br, gzip", Want: "gzip"},
+		{Name: "gzip refused", AcceptEncoding: "gzip;q=0", Want: ""},
+		{Name: "gzip refused among others", AcceptEncoding: "br, gzip; q=0.0, identity", Want: "br"},
+		{Name: "identity", AcceptEncoding: "identity", Want: ""},
+		{Name: "missing", AcceptEncoding: "", Want: ""},
+		{Name: "any", AcceptEncoding: "*", Want: "gzip"},
+		{Name: "any but gzip", AcceptEncoding: "*, gzip;q=0", Want: "br"},
+		{Name: "nothing", AcceptEncoding: "*;q=0", Want: ""},
+		{Name: "malformed q", AcceptEncoding: "gzip;q=abc", Want: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Want, chooseEncoding(tc.AcceptEncoding))
+		})
+	}
+}
+
+// The server compresses only with an accepted coding
+func Test_AcceptEncodingResponses(t *testing.T) {
+	setCompressMinSize(t, 0) // the short answers are compressed too
+
+	tests := []struct {
+		Name           string
+		AcceptEncoding string
+		WantEncoding   string
+	}{
+		{Name: "gzip", AcceptEncoding: "gzip", WantEncoding: "gzip"},
+		{Name: "br", AcceptEncoding: "br", WantEncoding: "br"},
+		{Name: "deflate", AcceptEncoding: "deflate", WantEncoding: "deflate"},
+		{Name: "br over gzip", AcceptEncoding: "gzip;q=0.5, br;q=0.9", WantEncoding: "br"},
+		{Name: "gzip;q=0", AcceptEncoding: "gzip;q=0", WantEncoding: ""},
+		{Name: "identity", AcceptEncoding: "identity", WantEncoding: ""},
+		{Name: "missing", AcceptEncoding: "", WantEncoding: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+			defer ts.Close()
+
+			// the transport must not add its own Accept-Encoding for the missing one
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/", bytes.NewBufferString("https://practicum.net"))
+			require.NoError(t, err)
+			if tc.AcceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.AcceptEncoding)
+			}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			require.Equal(t, tc.WantEncoding, resp.Header.Get("Content-Encoding"))
+			var body io.Reader = resp.Body
+			switch tc.WantEncoding {
+			case "gzip":
+				body, err = gzip.NewReader(resp.Body)
+			case "br":
+				body = brotli.NewReader(resp.Body)
+			case "deflate":
+				body, err = zlib.NewReader(resp.Body)
+			}
+			require.NoError(t, err)
+			shortURL, err := io.ReadAll(body)
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(string(shortURL), "/"))
+		})
+	}
+}
+
+// The encoder is engaged only by an answer with a body and closed once
+func Test_ResLogOrCompressClose(t *testing.T) {
+	setCompressMinSize(t, 0) // the short answers are compressed too
+
+	tests := []struct {
+		Name         string
+		Write        func(res http.ResponseWriter)
+		WantEncoding string
+		WantBody     string
+	}{
+		{
+			Name:     "No content",
+			Write:    func(res http.ResponseWriter) { res.WriteHeader(http.StatusNoContent) },
+			WantBody: "",
+		},
+		{
+			Name:     "Not modified",
+			Write:    func(res http.ResponseWriter) { res.WriteHeader(http.StatusNotModified) },
+			WantBody: "",
+		},
+		{
+			Name: "Abandoned halfway",
+			Write: func(res http.ResponseWriter) {
+				res.WriteHeader(http.StatusOK)
+				res.Write([]byte(`{"id":"sharaga"}`))
+			},
+			WantEncoding: "gzip",
+			WantBody:     `{"id":"sharaga"}`,
+		},
+		{
+			Name:         "Implicit 200",
+			Write:        func(res http.ResponseWriter) { res.Write([]byte("ok")) },
+			WantEncoding: "gzip",
+			WantBody:     "ok",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			logRW := &ResLogOrCompress{res: rec, data: &LogData{}, coding: "gzip"}
+			tc.Write(logRW)
+			require.NoError(t, logRW.Close())
+			require.NoError(t, logRW.Close())
+
+			require.Equal(t, tc.WantEncoding, rec.Header().Get("Content-Encoding"))
+			if tc.WantEncoding == "" {
+				require.Equal(t, tc.WantBody, rec.Body.String())
+				return
+			}
+			reader, err := gzip.NewReader(rec.Body)
+			require.NoError(t, err)
+			body, err := io.ReadAll(reader) // fails without the gzip trailer
+			require.NoError(t, err)
+			require.Equal(t, tc.WantBody, string(body))
+			require.Zero(t, rec.Body.Len(), "written after the stream end")
+		})
+	}
+}
+
+// The answer the handler encoded itself is passed through as is
+func Test_HandlerOwnEncoding(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write([]byte("https://mai.ru"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	for _, coding := range []string{"gzip", "br"} {
+		t.Run(coding, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			logRW := &ResLogOrCompress{res: rec, data: &LogData{}, coding: coding}
+			logRW.Header().Set("Content-Encoding", "gzip")
+			logRW.WriteHeader(http.StatusOK)
+			_, err := logRW.Write(compressed.Bytes())
+			require.NoError(t, err)
+			require.NoError(t, logRW.Close())
+
+			require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+			reader, err := gzip.NewReader(rec.Body)
+			require.NoError(t, err)
+			body, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			require.Equal(t, "https://mai.ru", string(body)) // decoded once
+		})
+	}
+}
+
+// A stream ended by an error halfway is still a complete gzip stream
+func Test_CompressedStreamEndedByError(t *testing.T) {
+	setCompressMinSize(t, 0) // the short answers are compressed too
+
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+	defer ts.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/batch/expand-stream", bytes.NewBufferString("{\"id\": \"sharaga\"}\n{\"id\": \n"))
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	reader, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, `{"id":"sharaga","original_url":"https://mai.ru","found":true}`+"\n"+`{"error":"Invalid NDJSON"}`+"\n", string(body))
+}
+
+// With the compression off a gzip client gets the plain answer, its gzip body is still read
+func Test_NoCompression(t *testing.T) {
+	oldNoCompression := config.NoCompression
+	config.NoCompression = true
+	defer func() { config.NoCompression = oldNoCompression }()
+
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+	defer ts.Close()
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write([]byte("https://practicum.net"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/", &compressed)
+	require.NoError(t, err)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept-Encoding", "gzip, br, deflate")
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Empty(t, resp.Header.Get("Content-Encoding"))
+	require.Empty(t, resp.Header.Values("Vary"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(body), "/"), "body %q", body)
+}
+
+// The answers shorter than the min size are sent as is with their status,
+// the longer ones are compressed
+func Test_CompressMinSize(t *testing.T) {
+	setCompressMinSize(t, 16)
+	tests := []struct {
+		Name         string
+		Writes       []string
+		WantEncoding string
+	}{
+		{Name: "Short", Writes: []string{"ok"}, WantEncoding: ""},
+		{Name: "Short pieces", Writes: []string{"0123", "4567", "89"}, WantEncoding: ""},
+		{Name: "Long", Writes: []string{strings.Repeat("a", 16)}, WantEncoding: "gzip"},
+		{Name: "Long pieces", Writes: []string{"0123456789", "0123456789", "end"}, WantEncoding: "gzip"},
+		{Name: "No body", WantEncoding: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			logRW := &ResLogOrCompress{res: rec, data: &LogData{}, coding: "gzip"}
+			logRW.WriteHeader(http.StatusCreated)
+			for _, part := range tc.Writes {
+				_, err := logRW.Write([]byte(part))
+				require.NoError(t, err)
+			}
+			require.NoError(t, logRW.Close())
+
+			require.Equal(t, http.StatusCreated, rec.Code)
+			require.Equal(t, tc.WantEncoding, rec.Header().Get("Content-Encoding"))
+			body := rec.Body.Bytes()
+			if tc.WantEncoding != "" {
+				reader, err := gzip.NewReader(rec.Body)
+				require.NoError(t, err)
+				body, err = io.ReadAll(reader)
+				require.NoError(t, err)
+			}
+			require.Equal(t, strings.Join(tc.Writes, ""), string(body))
+			require.Equal(t, len(body), logRW.data.size)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/absurd678/skill/cmd/config"
+)
+
+// splitList splits "a, b,c" into [a b c]
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// cors answers the preflight OPTIONS requests with 204 and adds Access-Control-Allow-*
+// headers for the configured origins ("*" allows any), with no origins CORS is off
+func cors(next http.Handler) http.Handler {
+	origins := map[string]bool{}
+	for _, origin := range splitList(config.CORSAllowedOrigins) {
+		origins[origin] = true
+	}
+	methods := strings.Join(splitList(config.CORSAllowedMethods), ", ")
+	headers := strings.Join(splitList(config.CORSAllowedHeaders), ", ")
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" || len(origins) == 0 {
+			next.ServeHTTP(res, req)
+			return
+		}
+		res.Header().Add("Vary", "Origin")
+		if !origins[origin] && !origins["*"] {
+			next.ServeHTTP(res, req) // no CORS headers, the browser blocks it
+			return
+		}
+
+		res.Header().Set("Access-Control-Allow-Origin", origin)
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			res.Header().Set("Access-Control-Allow-Methods", methods)
+			res.Header().Set("Access-Control-Allow-Headers", headers)
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+		res.Header().Set("Access-Control-Expose-Headers", shortIDHeader) // readable by the scripts
+		next.ServeHTTP(res, req)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CORS(t *testing.T) {
+	oldOrigins := config.CORSAllowedOrigins
+	config.CORSAllowedOrigins = "https://front.example, https://admin.example"
+	defer func() { config.CORSAllowedOrigins = oldOrigins }()
+
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+	defer ts.Close()
+
+	tests := []struct {
+		Name        string
+		Method      string
+		Origin      string
+		Preflight   bool
+		WantCode    int
+		WantOrigin  string
+		WantMethods string
+	}{
+		{
+			Name:        "Preflight",
+			Method:      http.MethodOptions,
+			Origin:      "https://front.example",
+			Preflight:   true,
+			WantCode:    http.StatusNoContent,
+			WantOrigin:  "https://front.example",
+			WantMethods: "GET, POST, OPTIONS",
+		},
+		{
+			Name:       "Cross-origin POST",
+			Method:     http.MethodPost,
+			Origin:     "https://admin.example",
+			WantCode:   http.StatusCreated,
+			WantOrigin: "https://admin.example",
+		},
+		{
+			Name:     "Not allowed origin",
+			Method:   http.MethodPost,
+			Origin:   "https://evil.example",
+			WantCode: http.StatusConflict, // the url was shortened by the case above
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.Method, ts.URL+"/api/shorten", bytes.NewBufferString(`{"url": "https://practicum.net"}`))
+			require.NoError(t, err)
+			req.Header.Set("Origin", tc.Origin)
+			if tc.Preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+				req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			require.Equal(t, tc.WantOrigin, resp.Header.Get("Access-Control-Allow-Origin"))
+			require.Equal(t, tc.WantMethods, resp.Header.Get("Access-Control-Allow-Methods"))
+			if tc.Preflight {
+				require.Equal(t, "Content-Type, Content-Encoding", resp.Header.Get("Access-Control-Allow-Headers"))
+			} else if tc.WantOrigin != "" {
+				require.Equal(t, "X-Short-Id", resp.Header.Get("Access-Control-Expose-Headers"))
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+
+	"github.com/absurd678/skill/cmd/config"
+)
+
+// counterID encodes the sequence number with the configured alphabet, most
+// significant digit first, so the ids are as short as the number allows
+func counterID(n uint64) string {
+	alphabet := config.ShortIDAlphabet
+	base := uint64(len(alphabet))
+	var b []byte
+	for {
+		b = append(b, alphabet[n%base])
+		n /= base
+		if n == 0 {
+			break
+		}
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+// candidateID is the next counter id in the counter mode, a random one otherwise
+func (c *Connection) candidateID(ctx context.Context) (string, error) {
+	if config.ShortIDMode != "counter" {
+		return newShortID(), nil
+	}
+	seq, err := c.store.NextSeq(ctx)
+	if err != nil {
+		return "", err
+	}
+	return counterID(seq), nil
+}
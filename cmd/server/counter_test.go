@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func Test_CounterID(t *testing.T) {
+	tests := []struct {
+		N    uint64
+		Want string
+	}{
+		{N: 0, Want: "a"},
+		{N: 1, Want: "b"},
+		{N: 61, Want: "0"},
+		{N: 62, Want: "ba"},
+		{N: 62*62 + 1, Want: "bab"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Want, func(t *testing.T) {
+			require.Equal(t, tc.Want, counterID(tc.N))
+		})
+	}
+}
+
+// The counter ids follow each other and go on after a restart of the file storage
+func Test_CounterMode(t *testing.T) {
+	oldMode := config.ShortIDMode
+	config.ShortIDMode = "counter"
+	defer func() { config.ShortIDMode = oldMode }()
+
+	path := filepath.Join(t.TempDir(), "urls.json")
+	shorten := func(store storage.Storage, original string) string {
+		ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+		defer ts.Close()
+		resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/", body: bytes.NewBufferString(original)})
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		return resp.Header.Get(shortIDHeader)
+	}
+
+	store, err := storage.NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	require.Equal(t, "b", shorten(store, "https://mai.ru"))
+	require.Equal(t, "c", shorten(store, "https://practicum.net"))
+	require.Equal(t, "d", shorten(store, "https://practicum.net/api"))
+	require.NoError(t, store.Close())
+
+	// the restart skips the rest of the reserved block
+	store, err = storage.NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	defer store.Close()
+	id := shorten(store, "https://mai.ru/news")
+	require.Equal(t, counterID(101), id)
+	original, ok := store.Get(context.Background(), "b")
+	require.True(t, ok)
+	require.Equal(t, "https://mai.ru", original)
+}
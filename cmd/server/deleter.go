@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/absurd678/skill/internal/storage"
+)
+
+const deleteQueueSize = 1024 // the batches waiting for the worker
+
+// Deleter soft deletes the urls in the background, the handler only enqueues
+// them. Shutdown closes the done channel, the worker applies what is still
+// queued and closes finished.
+type Deleter struct {
+	store    storage.Storage
+	queue    chan []string
+	done     chan struct{}
+	finished chan struct{}
+	mu       sync.Mutex // Enqueue and Shutdown
+	stopped  bool
+}
+
+func newDeleter(store storage.Storage, size int) *Deleter {
+	return &Deleter{
+		store:    store,
+		queue:    make(chan []string, size),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+}
+
+// Enqueue queues the batch, false when the queue is full or the worker stops
+func (d *Deleter) Enqueue(shortURLs []string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return false
+	}
+	select {
+	case d.queue <- shortURLs:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run deletes the queued batches until Shutdown, then drains the queue
+func (d *Deleter) Run() {
+	defer close(d.finished)
+	for {
+		select {
+		case shortURLs := <-d.queue:
+			d.delete(shortURLs)
+		case <-d.done:
+			for {
+				select {
+				case shortURLs := <-d.queue:
+					d.delete(shortURLs)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// the queued deletes outlive the request, so they don't use its context
+func (d *Deleter) delete(shortURLs []string) {
+	if err := d.store.Delete(context.Background(), shortURLs); err != nil {
+		logger.Sugar().Errorw("Deleting urls", "Count", len(shortURLs), "Error", err)
+	}
+}
+
+// Shutdown stops accepting batches and waits until the queued ones are
+// applied or ctx is done
+func (d *Deleter) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.stopped {
+		d.stopped = true
+		close(d.done)
+	}
+	d.mu.Unlock()
+	select {
+	case <-d.finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DeleteURLsHandler accepts ["id", ...] for the deletion and answers 202 at
+// once, without the worker the urls are deleted before the answer
+func (c *Connection) DeleteURLsHandler(res http.ResponseWriter, req *http.Request) {
+	var shortURLs []string
+	if err := decodeJSON(req.Body, &shortURLs); err != nil {
+		writeBodyError(res, err, "Invalid JSON")
+		return
+	}
+	if len(shortURLs) == 0 {
+		writeJSONError(res, http.StatusBadRequest, "Empty batch")
+		return
+	}
+
+	if c.deleter == nil {
+		err := c.store.Delete(req.Context(), shortURLs)
+		if contextDone(res, req) {
+			return
+		}
+		if err != nil {
+			writeJSONError(res, http.StatusInternalServerError, "Can't delete short URLs")
+			return
+		}
+	} else if !c.deleter.Enqueue(shortURLs) {
+		writeJSONError(res, http.StatusServiceUnavailable, "Deletion queue is full")
+		return
+	}
+	res.WriteHeader(http.StatusAccepted)
+}
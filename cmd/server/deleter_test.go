@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// The deletes queued before the shutdown are all applied
+func Test_DeleterShutdownDrains(t *testing.T) {
+	store := storage.NewMemStorage(map[string]string{
+		"first": "https://mai.ru", "second": "https://practicum.net", "third": "https://ilovespb.ru", "kept": "https://go.dev",
+	})
+	deleter := newDeleter(store, 8)
+	require.True(t, deleter.Enqueue([]string{"first"}))
+	require.True(t, deleter.Enqueue([]string{"second", "third"}))
+	require.True(t, deleter.Enqueue([]string{"unknown"}))
+
+	go deleter.Run()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, deleter.Shutdown(ctx))
+
+	for _, id := range []string{"first", "second", "third"} {
+		require.True(t, store.IsDeleted(context.Background(), id), id)
+	}
+	require.False(t, store.IsDeleted(context.Background(), "kept"))
+	require.False(t, deleter.Enqueue([]string{"kept"}), "enqueued after the shutdown")
+	require.NoError(t, deleter.Shutdown(ctx), "the second shutdown")
+}
+
+// Shutdown gives up waiting when ctx is done
+func Test_DeleterShutdownTimeout(t *testing.T) {
+	deleter := newDeleter(storage.NewMemStorage(nil), 1) // never run
+	require.True(t, deleter.Enqueue([]string{"first"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, deleter.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+func Test_DeleteURLsHandler(t *testing.T) {
+	oldSubnet := config.TrustedSubnet
+	config.TrustedSubnet = "127.0.0.0/8"
+	defer func() { config.TrustedSubnet = oldSubnet }()
+
+	tests := []struct {
+		Name        string
+		Body        string
+		Worker      bool
+		QueueSize   int
+		WantCode    int
+		WantDeleted bool
+	}{
+		{
+			Name:        "Queued",
+			Body:        `["sharaga"]`,
+			Worker:      true,
+			QueueSize:   1,
+			WantCode:    http.StatusAccepted,
+			WantDeleted: true,
+		},
+		{
+			Name:        "Without the worker",
+			Body:        `["sharaga"]`,
+			WantCode:    http.StatusAccepted,
+			WantDeleted: true,
+		},
+		{
+			Name:      "Queue full",
+			Body:      `["sharaga"]`,
+			Worker:    true,
+			QueueSize: 0,
+			WantCode:  http.StatusServiceUnavailable,
+		},
+		{
+			Name:     "Empty batch",
+			Body:     `[]`,
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "Invalid JSON",
+			Body:     `"sharaga"`,
+			WantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			store := storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+			c := NewConnection(store)
+			if tc.Worker {
+				c.deleter = newDeleter(store, tc.QueueSize) // not run: the queue only fills
+			}
+			ts := httptest.NewServer(LaunchMyRouter(c))
+			defer ts.Close()
+
+			resp := testRequest(testRequestOptions{
+				t:      t,
+				ts:     ts,
+				method: http.MethodDelete,
+				path:   "/api/internal/urls",
+				body:   bytes.NewBufferString(tc.Body),
+			})
+			resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+
+			if tc.Worker {
+				go c.deleter.Run()
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				require.NoError(t, c.deleter.Shutdown(ctx))
+			}
+			require.Equal(t, tc.WantDeleted, store.IsDeleted(context.Background(), "sharaga"))
+		})
+	}
+}
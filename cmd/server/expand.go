@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/absurd678/skill/internal/models"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// ExpandHandler answers the short url metadata without redirecting,
+// 404 for an unknown id and 410 with the metadata for a deleted one
+func (c *Connection) ExpandHandler(res http.ResponseWriter, req *http.Request) {
+	shortURL := chi.URLParam(req, "id")
+	original, err := c.store.Lookup(req.Context(), shortURL)
+	if contextDone(res, req) {
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		writeJSONError(res, http.StatusNotFound, "Unknown short URL")
+		return
+	}
+	if err != nil {
+		writeStorageError(res, err)
+		return
+	}
+	deleted := c.store.IsDeleted(req.Context(), shortURL)
+	created, _ := c.store.CreatedAt(req.Context(), shortURL)
+	hits := c.store.Hits(req.Context(), shortURL)
+	if contextDone(res, req) {
+		return
+	}
+	buff, err := json.Marshal(models.ExpandedURL{ShortURL: shortURL, OriginalURL: original, Deleted: deleted, CreatedAt: created, Hits: hits})
+	if err != nil {
+		writeJSONError(res, http.StatusInternalServerError, "Unmarshable data")
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	if deleted {
+		res.WriteHeader(http.StatusGone)
+	} else {
+		res.WriteHeader(http.StatusOK)
+	}
+	res.Write(buff)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExpandHandler(t *testing.T) {
+	store := storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru", "old": "https://practicum.net"})
+	require.NoError(t, store.Delete(context.Background(), []string{"old"}))
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+	defer ts.Close()
+
+	tests := []struct {
+		Name     string
+		Path     string
+		WantCode int
+		WantURL  models.ExpandedURL
+	}{
+		{
+			Name:     "Found",
+			Path:     "/api/expand/sharaga",
+			WantCode: http.StatusOK,
+			WantURL:  models.ExpandedURL{ShortURL: "sharaga", OriginalURL: "https://mai.ru"},
+		},
+		{
+			Name:     "Not found",
+			Path:     "/api/expand/test",
+			WantCode: http.StatusNotFound,
+		},
+		{
+			Name:     "Deleted",
+			Path:     "/api/expand/old",
+			WantCode: http.StatusGone,
+			WantURL:  models.ExpandedURL{ShortURL: "old", OriginalURL: "https://practicum.net", Deleted: true},
+		},
+		{
+			Name:     "Extra path",
+			Path:     "/api/expand/sharaga/more",
+			WantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: tc.Path})
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			if tc.WantURL.ShortURL == "" {
+				return
+			}
+			var expanded models.ExpandedURL
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&expanded))
+			created, ok := store.CreatedAt(context.Background(), tc.WantURL.ShortURL)
+			require.True(t, ok)
+			require.True(t, created.Equal(expanded.CreatedAt), "created_at %s, want %s", expanded.CreatedAt, created)
+			expanded.CreatedAt = time.Time{}
+			require.Equal(t, tc.WantURL, expanded)
+		})
+	}
+}
+
+// Every redirect is counted in the hits, HEAD is not
+func Test_ExpandHits(t *testing.T) {
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+	defer ts.Close()
+
+	for i := 0; i < 3; i++ {
+		resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/sharaga"})
+		resp.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	}
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodHead, path: "/sharaga"})
+	resp.Body.Close()
+	resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/unknown"})
+	resp.Body.Close()
+
+	resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/api/expand/sharaga"})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var expanded models.ExpandedURL
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&expanded))
+	require.Equal(t, int64(3), expanded.Hits)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/models"
+)
+
+// extendDeadlines moves the server read and write timeouts of the connection to
+// config.StreamTimeout (0 - none), a large stream outlasts them
+func extendDeadlines(res http.ResponseWriter) {
+	var deadline time.Time
+	if config.StreamTimeout > 0 {
+		deadline = time.Now().Add(config.StreamTimeout)
+	}
+	rc := http.NewResponseController(res)
+	rc.SetReadDeadline(deadline) // not supported by e.g. the test recorders, the timeouts stay then
+	rc.SetWriteDeadline(deadline)
+}
+
+// resolve looks the short url up, a deleted one isn't found
+func (c *Connection) resolve(req *http.Request, id string) models.Resolution {
+	original, ok := c.store.Get(req.Context(), id)
+	if !ok || c.store.IsDeleted(req.Context(), id) {
+		return models.Resolution{ID: id}
+	}
+	return models.Resolution{ID: id, OriginalURL: original, Found: true}
+}
+
+// ExpandStreamHandler reads the NDJSON {"id": "..."} lines one by one (checkURL
+// has already decompressed a gzipped body) and writes a resolution line for each
+// without holding the batch in memory. An invalid line after the first one
+// can't change the status, so it ends the stream with an error line, as does
+// the body reaching config.StreamMaxBodySize.
+func (c *Connection) ExpandStreamHandler(res http.ResponseWriter, req *http.Request) {
+	extendDeadlines(res)
+	decoder := json.NewDecoder(req.Body)
+	encoder := json.NewEncoder(res)
+	started := false
+	for {
+		var item models.BatchID
+		err := decoder.Decode(&item)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if started && req.Context().Err() != nil {
+			return // too late for 503
+		}
+		if !started && contextDone(res, req) {
+			return
+		}
+		if err != nil && !started {
+			writeBodyError(res, err, "Invalid NDJSON")
+			return
+		}
+		if !started {
+			res.Header().Set("Content-Type", "application/x-ndjson")
+			res.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if err != nil {
+			message := "Invalid NDJSON"
+			if bodyTooLarge(err) {
+				message = "Request body is too large"
+			}
+			encoder.Encode(models.ErrorResponse{Error: message})
+			return
+		}
+		if err = encoder.Encode(c.resolve(req, item.ID)); err != nil {
+			return // the client is gone
+		}
+	}
+	if !started { // an empty stream
+		res.Header().Set("Content-Type", "application/x-ndjson")
+		res.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExpandStreamHandler(t *testing.T) {
+	store := storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru", "old": "https://practicum.net"})
+	require.NoError(t, store.Delete(context.Background(), []string{"old"}))
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+	defer ts.Close()
+
+	ndjson := "{\"id\": \"sharaga\"}\n{\"id\": \"test\"}\n\n{\"id\": \"old\"}\n"
+	gzipped := bytes.NewBuffer(nil)
+	writer := gzip.NewWriter(gzipped)
+	_, err := writer.Write([]byte(ndjson))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	resolved := `{"id":"sharaga","original_url":"https://mai.ru","found":true}` + "\n" +
+		`{"id":"test","original_url":"","found":false}` + "\n" +
+		`{"id":"old","original_url":"","found":false}` + "\n"
+
+	tests := []struct {
+		Name     string
+		Body     []byte
+		Gzip     bool
+		WantCode int
+		WantBody string
+	}{
+		{
+			Name:     "Gzipped",
+			Body:     gzipped.Bytes(),
+			Gzip:     true,
+			WantCode: http.StatusOK,
+			WantBody: resolved,
+		},
+		{
+			Name:     "Plain",
+			Body:     []byte(ndjson),
+			WantCode: http.StatusOK,
+			WantBody: resolved,
+		},
+		{
+			Name:     "Empty",
+			WantCode: http.StatusOK,
+		},
+		{
+			Name:     "Invalid first line",
+			Body:     []byte("sharaga\n"),
+			WantCode: http.StatusBadRequest,
+			WantBody: `{"error":"Invalid NDJSON"}`,
+		},
+		{
+			Name:     "Invalid later line",
+			Body:     []byte("{\"id\": \"sharaga\"}\n{\"id\": \n"),
+			WantCode: http.StatusOK,
+			WantBody: `{"id":"sharaga","original_url":"https://mai.ru","found":true}` + "\n" + `{"error":"Invalid NDJSON"}` + "\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/batch/expand-stream", bytes.NewReader(tc.Body))
+			require.NoError(t, err)
+			if tc.Gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			if tc.WantCode == http.StatusOK {
+				require.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+				require.Equal(t, tc.WantBody, string(body))
+			} else {
+				require.JSONEq(t, tc.WantBody, string(body))
+			}
+		})
+	}
+}
+
+// The stream is over MaxBodySize, it is capped by StreamMaxBodySize instead
+func Test_ExpandStreamLargeBody(t *testing.T) {
+	oldMaxBodySize, oldStreamMaxBodySize := config.MaxBodySize, config.StreamMaxBodySize
+	defer func() { config.MaxBodySize, config.StreamMaxBodySize = oldMaxBodySize, oldStreamMaxBodySize }()
+	config.MaxBodySize = 1 << 20
+
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+	defer ts.Close()
+
+	const lines = 100_000
+	ndjson := strings.Repeat("{\"id\": \"sharaga\"}\n", lines)
+	require.Greater(t, int64(len(ndjson)), config.MaxBodySize)
+	resolved := `{"id":"sharaga","original_url":"https://mai.ru","found":true}`
+
+	tests := []struct {
+		Name          string
+		StreamMaxSize int64
+		WantLast      string // the last line, an error once the stream is cut
+	}{
+		{Name: "Within the stream limit", StreamMaxSize: 100 << 20, WantLast: resolved},
+		{Name: "No stream limit", StreamMaxSize: 0, WantLast: resolved},
+		{Name: "Over the stream limit", StreamMaxSize: 1 << 10, WantLast: `{"error":"Request body is too large"}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			config.StreamMaxBodySize = tc.StreamMaxSize
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/api/batch/expand-stream", body: strings.NewReader(ndjson)})
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			got := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+			require.Equal(t, tc.WantLast, got[len(got)-1])
+			if tc.WantLast == resolved {
+				require.Len(t, got, lines)
+			}
+		})
+	}
+}
+
+// A slow stream outlasts RequestTimeout and the server read timeout
+func Test_ExpandStreamSlowBody(t *testing.T) {
+	oldRequestTimeout, oldStreamTimeout := config.RequestTimeout, config.StreamTimeout
+	defer func() { config.RequestTimeout, config.StreamTimeout = oldRequestTimeout, oldStreamTimeout }()
+	config.RequestTimeout, config.StreamTimeout = 50*time.Millisecond, time.Minute
+
+	ts := httptest.NewUnstartedServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+	ts.Config.ReadTimeout = 50 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	body, writer := io.Pipe()
+	go func() {
+		for i := 0; i < 3; i++ {
+			writer.Write([]byte("{\"id\": \"sharaga\"}\n"))
+			time.Sleep(60 * time.Millisecond)
+		}
+		writer.Close()
+	}()
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/api/batch/expand-stream", body: body})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resolved, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat(`{"id":"sharaga","original_url":"https://mai.ru","found":true}`+"\n", 3), string(resolved))
+}
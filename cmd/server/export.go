@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/absurd678/skill/internal/models"
+	"github.com/absurd678/skill/internal/storage"
+)
+
+// ExportHandler streams every stored url as an NDJSON line, for the backups
+// and the moves between the storages. A failure after the first line can't
+// change the status, so it ends the stream with an error line.
+func (c *Connection) ExportHandler(res http.ResponseWriter, req *http.Request) {
+	encoder := json.NewEncoder(res)
+	started := false
+	start := func() {
+		res.Header().Set("Content-Type", "application/x-ndjson")
+		res.WriteHeader(http.StatusOK)
+		started = true
+	}
+	err := c.store.Export(req.Context(), func(url models.ExportedURL) error {
+		if !started {
+			start()
+		}
+		return encoder.Encode(url)
+	})
+	switch {
+	case err == nil && !started: // an empty storage
+		start()
+	case err == nil:
+	case !started:
+		if contextDone(res, req) {
+			return
+		}
+		writeStorageError(res, err)
+	case req.Context().Err() == nil:
+		logger.Sugar().Errorw("Export interrupted", "Error", err)
+		encoder.Encode(models.ErrorResponse{Error: "Storage error"})
+	}
+}
+
+// ImportHandler saves the NDJSON lines of the export one by one with their
+// creation time, the urls already stored are overwritten. The lines before an invalid one stay saved,
+// a big export is imported in parts under the MAX_BODY_SIZE limit.
+func (c *Connection) ImportHandler(res http.ResponseWriter, req *http.Request) {
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	imported := 0
+	for {
+		var url models.ExportedURL
+		err := decoder.Decode(&url)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writeBodyError(res, err, fmt.Sprintf("Invalid NDJSON after %d imported lines", imported))
+			return
+		}
+		if url.ID == "" || url.OriginalURL == "" {
+			writeJSONError(res, http.StatusBadRequest, fmt.Sprintf("Line %d needs an id and an original_url", imported+1))
+			return
+		}
+		if !importedURLsValid(url) {
+			writeJSONError(res, http.StatusBadRequest, fmt.Sprintf("Line %d: the urls must be absolute http(s) URLs", imported+1))
+			return
+		}
+		if err = c.store.Import(req.Context(), url); err != nil {
+			if contextDone(res, req) {
+				return
+			}
+			if errors.Is(err, storage.ErrIDTaken) {
+				writeJSONError(res, http.StatusConflict, fmt.Sprintf("Line %d: the id %s is taken", imported+1, url.ID))
+				return
+			}
+			writeStorageError(res, err)
+			return
+		}
+		imported++
+	}
+
+	buff, err := json.Marshal(models.ImportResult{Imported: imported})
+	if err != nil {
+		writeJSONError(res, http.StatusInternalServerError, "Unmarshable data")
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(buff)
+}
+
+// importedURLsValid checks the original url and the targets of the weighted one
+func importedURLsValid(url models.ExportedURL) bool {
+	if !validURL(url.OriginalURL) {
+		return false
+	}
+	for _, target := range url.Targets {
+		if !validURL(target.URL) {
+			return false
+		}
+	}
+	return true
+}
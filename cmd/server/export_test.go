@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/models"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+var exportedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+var wantExport = []models.ExportedURL{
+	{ID: "ab", OriginalURL: "https://a.ru", Targets: []models.Target{{URL: "https://a.ru", Weight: 1}, {URL: "https://b.ru", Weight: 3}}, CreatedAt: &exportedAt},
+	{ID: "old", OriginalURL: "https://practicum.net", Deleted: true, CreatedAt: &exportedAt},
+	{ID: "sharaga", OriginalURL: "https://mai.ru", CreatedAt: &exportedAt},
+}
+
+// exportedStore keeps a plain, a deleted and a weighted url
+func exportedStore(t *testing.T) *storage.MemStorage {
+	store := storage.NewMemStorage(nil)
+	for _, url := range wantExport {
+		require.NoError(t, store.Import(context.Background(), url))
+	}
+	return store
+}
+
+// readExport decodes the NDJSON lines of the export
+func readExport(t *testing.T, body io.Reader) []models.ExportedURL {
+	var urls []models.ExportedURL
+	decoder := json.NewDecoder(body)
+	for {
+		var url models.ExportedURL
+		err := decoder.Decode(&url)
+		if errors.Is(err, io.EOF) {
+			return urls
+		}
+		require.NoError(t, err)
+		urls = append(urls, url)
+	}
+}
+
+func Test_ExportHandler(t *testing.T) {
+	oldSubnet := config.TrustedSubnet
+	config.TrustedSubnet = "127.0.0.0/8"
+	defer func() { config.TrustedSubnet = oldSubnet }()
+
+	tests := []struct {
+		Name     string
+		Store    storage.Storage
+		WantURLs []models.ExportedURL
+	}{
+		{Name: "All urls", Store: exportedStore(t), WantURLs: wantExport},
+		{Name: "Empty storage", Store: storage.NewMemStorage(nil), WantURLs: nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(tc.Store)))
+			defer ts.Close()
+
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/api/internal/export"})
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, tc.WantURLs, readExport(t, bytes.NewReader(body)))
+			for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+				if line != "" {
+					require.Contains(t, line, `"user_id":""`)
+				}
+			}
+		})
+	}
+}
+
+func Test_ExportHandlerForbidden(t *testing.T) {
+	oldSubnet := config.TrustedSubnet
+	config.TrustedSubnet = "192.168.1.0/24"
+	defer func() { config.TrustedSubnet = oldSubnet }()
+
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(exportedStore(t))))
+	defer ts.Close()
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/api/internal/export"})
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+// The export imported into an empty storage exports the same
+func Test_ImportHandler(t *testing.T) {
+	oldSubnet := config.TrustedSubnet
+	config.TrustedSubnet = "127.0.0.0/8"
+	defer func() { config.TrustedSubnet = oldSubnet }()
+
+	var export bytes.Buffer
+	encoder := json.NewEncoder(&export)
+	for _, url := range wantExport {
+		require.NoError(t, encoder.Encode(url))
+	}
+
+	tests := []struct {
+		Name         string
+		Body         string
+		WantCode     int
+		WantImported int
+		WantURLs     []models.ExportedURL
+	}{
+		{
+			Name:         "Export",
+			Body:         export.String(),
+			WantCode:     http.StatusOK,
+			WantImported: 3,
+			WantURLs:     wantExport,
+		},
+		{
+			Name:     "Invalid line",
+			Body:     `{"id": "sharaga", "original_url": "https://mai.ru", "deleted": false}` + "\n" + `{"id": `,
+			WantCode: http.StatusBadRequest,
+			WantURLs: []models.ExportedURL{{ID: "sharaga", OriginalURL: "https://mai.ru"}},
+		},
+		{
+			Name: "Taken weighted id",
+			Body: `{"id": "ab", "original_url": "https://a.ru", "targets": [{"url": "https://a.ru", "weight": 1}]}` + "\n" +
+				`{"id": "ab", "original_url": "https://b.ru", "targets": [{"url": "https://b.ru", "weight": 1}]}`,
+			WantCode: http.StatusConflict,
+			WantURLs: []models.ExportedURL{{ID: "ab", OriginalURL: "https://a.ru", Targets: []models.Target{{URL: "https://a.ru", Weight: 1}}}},
+		},
+		{
+			Name:         "No created at",
+			Body:         `{"id": "sharaga", "original_url": "https://mai.ru", "user_id": "", "deleted": false}`,
+			WantCode:     http.StatusOK,
+			WantImported: 1,
+			WantURLs:     []models.ExportedURL{{ID: "sharaga", OriginalURL: "https://mai.ru"}}, // created now
+		},
+		{
+			Name:     "No original url",
+			Body:     `{"id": "sharaga", "deleted": false}`,
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "Invalid original url",
+			Body:     `{"id": "sharaga", "original_url": "javascript:alert(1)", "deleted": false}`,
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "Invalid target url",
+			Body:     `{"id": "ab", "original_url": "https://a.ru", "targets": [{"url": "https://a.ru", "weight": 1}, {"url": "/b", "weight": 1}]}`,
+			WantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			store := storage.NewMemStorage(nil)
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+			defer ts.Close()
+
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/api/internal/import", body: bytes.NewBufferString(tc.Body)})
+			defer resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			if tc.WantCode == http.StatusOK {
+				var result models.ImportResult
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+				require.Equal(t, tc.WantImported, result.Imported)
+			}
+
+			var urls []models.ExportedURL
+			require.NoError(t, store.Export(context.Background(), func(url models.ExportedURL) error {
+				urls = append(urls, url)
+				return nil
+			}))
+			require.Len(t, urls, len(tc.WantURLs))
+			for i, url := range urls {
+				require.NotNil(t, url.CreatedAt)
+				if tc.WantURLs[i].CreatedAt == nil { // created at the import
+					urls[i].CreatedAt = nil
+				}
+			}
+			require.Equal(t, tc.WantURLs, urls)
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// ----------------------STRUCTURES----------------------------
+type (
+	Favicon struct {
+		contentType string
+		data        []byte
+	}
+
+	// FaviconCache keeps the fetched icons by the favicon URL
+	FaviconCache struct {
+		mu     sync.RWMutex
+		icons  map[string]Favicon
+		client *http.Client // replaced by the tests to reach the local sites
+	}
+)
+
+var (
+	errFaviconTooLarge   = errors.New("favicon is too large")
+	errFaviconNotImage   = errors.New("favicon is not an image")
+	errFaviconForbidden  = errors.New("favicon host is not public")
+	errFaviconRedirected = errors.New("favicon redirected too many times")
+)
+
+const (
+	maxFavicons         = 1000 // cached icons, one is dropped for a new one beyond
+	maxFaviconRedirects = 3
+)
+
+// ----------------------FaviconCache----------------------------
+func newFaviconCache() *FaviconCache {
+	return &FaviconCache{icons: map[string]Favicon{}, client: newFaviconClient(publicIP)}
+}
+
+func (fc *FaviconCache) get(iconURL string) (Favicon, bool) {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	icon, ok := fc.icons[iconURL]
+	return icon, ok
+}
+
+func (fc *FaviconCache) set(iconURL string, icon Favicon) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if _, ok := fc.icons[iconURL]; !ok && len(fc.icons) >= maxFavicons {
+		for cached := range fc.icons { // any one, the map order is random
+			delete(fc.icons, cached)
+			break
+		}
+	}
+	fc.icons[iconURL] = icon
+}
+
+// ----------------------FaviconCache----------------------------
+
+// publicIP reports whether the address is on the internet, not the loopback,
+// a private or a link-local network of the server
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// newFaviconClient connects to the addresses allowed only, checked after the name
+// is resolved for every connection and redirect, no proxy
+func newFaviconClient(allowed func(ip net.IP) bool) *http.Client {
+	dialer := &net.Dialer{
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowed(ip) {
+				return errFaviconForbidden
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   config.FaviconTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxFaviconRedirects {
+				return errFaviconRedirected
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("favicon redirected to %s", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// faviconURL returns /favicon.ico of the original URL's site
+func faviconURL(original string) (string, error) {
+	u, err := url.Parse(original)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("not an absolute URL: %s", original)
+	}
+	return u.Scheme + "://" + u.Host + "/favicon.ico", nil
+}
+
+// fetchFavicon downloads the icon with the configured timeout and size cap,
+// anything but an image is refused
+func fetchFavicon(ctx context.Context, client *http.Client, iconURL string) (Favicon, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, iconURL, nil)
+	if err != nil {
+		return Favicon{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Favicon{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Favicon{}, fmt.Errorf("favicon status: %s", resp.Status)
+	}
+
+	// read one byte more than allowed to find out the icon is too large
+	data, err := io.ReadAll(io.LimitReader(resp.Body, config.FaviconMaxSize+1))
+	if err != nil {
+		return Favicon{}, err
+	}
+	if int64(len(data)) > config.FaviconMaxSize {
+		return Favicon{}, errFaviconTooLarge
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	// served from our origin, so an html "icon" would run as our page
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || !strings.HasPrefix(mediaType, "image/") {
+		return Favicon{}, errFaviconNotImage
+	}
+	return Favicon{contentType: contentType, data: data}, nil
+}
+
+// IconHandler serves the favicon of the original URL, fetched once and cached
+func (c *Connection) IconHandler(res http.ResponseWriter, req *http.Request) {
+	shortURL := chi.URLParam(req, "id")
+	original, err := c.store.Lookup(req.Context(), shortURL)
+	if contextDone(res, req) {
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		writeJSONError(res, http.StatusNotFound, "Unknown short URL")
+		return
+	}
+	if err != nil {
+		writeStorageError(res, err)
+		return
+	}
+	if c.store.IsDeleted(req.Context(), shortURL) {
+		writeJSONError(res, http.StatusGone, "Short URL is deleted")
+		return
+	}
+	iconURL, err := faviconURL(original)
+	if err != nil {
+		writeJSONError(res, http.StatusBadRequest, "Invalid original URL")
+		return
+	}
+
+	icon, ok := c.icons.get(iconURL)
+	if !ok {
+		if icon, err = fetchFavicon(req.Context(), c.icons.client, iconURL); err != nil {
+			if contextDone(res, req) {
+				return
+			}
+			writeJSONError(res, http.StatusBadGateway, "Can't fetch favicon")
+			return
+		}
+		c.icons.set(iconURL, icon)
+	}
+
+	res.Header().Set("Content-Type", icon.contentType)
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(http.StatusOK)
+	res.Write(icon.data)
+}
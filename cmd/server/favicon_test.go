@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_IconHandler(t *testing.T) {
+	icon := []byte("\x00\x00\x01\x00fake icon")
+	serveIcon := func(contentType string) http.HandlerFunc {
+		return func(res http.ResponseWriter, req *http.Request) {
+			res.Header().Set("Content-Type", contentType)
+			res.Write(icon)
+		}
+	}
+
+	oldEnabled, oldMaxSize := config.FaviconEnabled, config.FaviconMaxSize
+	defer func() { config.FaviconEnabled, config.FaviconMaxSize = oldEnabled, oldMaxSize }()
+
+	tests := []struct {
+		Name     string
+		Enabled  bool
+		MaxSize  int64
+		Path     string
+		Site     http.HandlerFunc // the destination's /favicon.ico
+		Local    bool             // the local destination is allowed
+		WantCode int
+		WantHits int32 // destination hits after two requests
+	}{
+		{
+			Name:     "Cached icon",
+			Enabled:  true,
+			MaxSize:  1024,
+			Path:     "/site/icon",
+			Site:     serveIcon("image/x-icon"),
+			Local:    true,
+			WantCode: http.StatusOK,
+			WantHits: 1,
+		},
+		{
+			Name:     "Icon too large",
+			Enabled:  true,
+			MaxSize:  4,
+			Path:     "/site/icon",
+			Site:     serveIcon("image/x-icon"),
+			Local:    true,
+			WantCode: http.StatusBadGateway,
+			WantHits: 2,
+		},
+		{
+			Name:     "Not an image",
+			Enabled:  true,
+			MaxSize:  1024,
+			Path:     "/site/icon",
+			Site:     serveIcon("text/html; charset=utf-8"),
+			Local:    true,
+			WantCode: http.StatusBadGateway,
+			WantHits: 2,
+		},
+		{
+			Name:    "Redirect loop",
+			Enabled: true,
+			MaxSize: 1024,
+			Path:    "/site/icon",
+			Site: func(res http.ResponseWriter, req *http.Request) {
+				http.Redirect(res, req, "/favicon.ico", http.StatusFound)
+			},
+			Local:    true,
+			WantCode: http.StatusBadGateway,
+			WantHits: 2 * (maxFaviconRedirects + 1),
+		},
+		{
+			Name:     "Local site",
+			Enabled:  true,
+			MaxSize:  1024,
+			Path:     "/site/icon",
+			Site:     serveIcon("image/x-icon"),
+			WantCode: http.StatusBadGateway,
+		},
+		{
+			Name:     "Unknown id",
+			Enabled:  true,
+			MaxSize:  1024,
+			Path:     "/test/icon",
+			Site:     serveIcon("image/x-icon"),
+			Local:    true,
+			WantCode: http.StatusNotFound,
+		},
+		{
+			Name:     "Deleted",
+			Enabled:  true,
+			MaxSize:  1024,
+			Path:     "/old/icon",
+			Site:     serveIcon("image/x-icon"),
+			Local:    true,
+			WantCode: http.StatusGone,
+		},
+		{
+			Name:     "Disabled",
+			Enabled:  false,
+			MaxSize:  1024,
+			Path:     "/site/icon",
+			Site:     serveIcon("image/x-icon"),
+			Local:    true,
+			WantCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			config.FaviconEnabled, config.FaviconMaxSize = tc.Enabled, tc.MaxSize
+
+			// mock destination site serving a favicon
+			var hits atomic.Int32
+			destination := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != "/favicon.ico" {
+					res.WriteHeader(http.StatusNotFound)
+					return
+				}
+				hits.Add(1)
+				tc.Site(res, req)
+			}))
+			defer destination.Close()
+
+			store := storage.NewMemStorage(map[string]string{"site": destination.URL + "/some/page", "old": destination.URL})
+			require.NoError(t, store.Delete(context.Background(), []string{"old"}))
+			c := NewConnection(store)
+			if tc.Local {
+				c.icons.client = newFaviconClient(func(net.IP) bool { return true })
+			}
+			ts := httptest.NewServer(LaunchMyRouter(c))
+			defer ts.Close()
+
+			for i := 0; i < 2; i++ {
+				resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: tc.Path})
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				require.NoError(t, err)
+				require.Equal(t, tc.WantCode, resp.StatusCode)
+				if tc.WantCode == http.StatusOK {
+					require.Equal(t, "image/x-icon", resp.Header.Get("Content-Type"))
+					require.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+					require.Equal(t, icon, body)
+				}
+			}
+			require.Equal(t, tc.WantHits, hits.Load())
+		})
+	}
+}
+
+func Test_PublicIP(t *testing.T) {
+	tests := []struct {
+		IP   string
+		Want bool
+	}{
+		{IP: "93.184.216.34", Want: true},
+		{IP: "2606:4700::1111", Want: true},
+		{IP: "127.0.0.1"},
+		{IP: "::1"},
+		{IP: "10.1.2.3"},
+		{IP: "192.168.0.1"},
+		{IP: "172.16.0.1"},
+		{IP: "169.254.169.254"},
+		{IP: "fe80::1"},
+		{IP: "fd00::1"},
+		{IP: "0.0.0.0"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.IP, func(t *testing.T) {
+			require.Equal(t, tc.Want, publicIP(net.ParseIP(tc.IP)))
+		})
+	}
+}
+
+// The cache keeps at most maxFavicons icons
+func Test_FaviconCacheLimit(t *testing.T) {
+	fc := newFaviconCache()
+	for i := 0; i < maxFavicons+10; i++ {
+		fc.set("https://site"+strconv.Itoa(i)+".example/favicon.ico", Favicon{contentType: "image/png"})
+	}
+	require.Len(t, fc.icons, maxFavicons)
+	_, ok := fc.get("https://site" + strconv.Itoa(maxFavicons+9) + ".example/favicon.ico")
+	require.True(t, ok, "the newest icon is kept")
+}
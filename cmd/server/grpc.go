@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/absurd678/skill/internal/models"
+	pb "github.com/absurd678/skill/internal/proto"
+	"github.com/absurd678/skill/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ShortenerServer serves the gRPC API from the connection of the HTTP one
+type ShortenerServer struct {
+	pb.UnimplementedShortenerServer
+	c *Connection
+}
+
+func newGRPCServer(c *Connection) *grpc.Server {
+	server := grpc.NewServer()
+	pb.RegisterShortenerServer(server, &ShortenerServer{c: c})
+	return server
+}
+
+// stopGRPC lets the calls in flight finish until ctx is done, then cuts them
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logger.Sugar().Errorw("gRPC server shutdown", "Error", ctx.Err())
+		server.Stop()
+	}
+}
+
+// grpcError maps the failure like the HTTP API does: the cancelled requests,
+// the exhausted ids and the storage failures (logged)
+func grpcError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	if errors.Is(err, errIDsExhausted) {
+		return status.Error(codes.ResourceExhausted, "Can't generate short URL")
+	}
+	logger.Sugar().Errorw("gRPC storage error", "Error", err)
+	return status.Error(codes.Internal, "Storage error")
+}
+
+func (s *ShortenerServer) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	if strings.TrimSpace(req.GetOriginalUrl()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "The original_url must not be empty")
+	}
+	if !validURL(req.GetOriginalUrl()) {
+		return nil, status.Error(codes.InvalidArgument, "The original_url must be an absolute http(s) URL")
+	}
+	id, existed, err := s.c.shorten(ctx, req.GetOriginalUrl())
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &pb.ShortenResponse{ShortUrl: id, Existed: existed}, nil
+}
+
+func (s *ShortenerServer) Resolve(ctx context.Context, req *pb.ResolveRequest) (*pb.ResolveResponse, error) {
+	original, err := s.c.store.Lookup(ctx, req.GetShortUrl())
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "Unknown short URL")
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	if s.c.store.IsDeleted(ctx, req.GetShortUrl()) {
+		return nil, status.Error(codes.NotFound, "Short URL is deleted")
+	}
+	return &pb.ResolveResponse{OriginalUrl: original}, nil
+}
+
+func (s *ShortenerServer) BatchShorten(ctx context.Context, req *pb.BatchShortenRequest) (*pb.BatchShortenResponse, error) {
+	if len(req.GetUrls()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Empty batch")
+	}
+	batch := make([]models.BatchURL, 0, len(req.GetUrls()))
+	for _, item := range req.GetUrls() {
+		if item.GetOriginalUrl() == "" {
+			return nil, status.Error(codes.InvalidArgument, "Every item needs an original_url")
+		}
+		if !validURL(item.GetOriginalUrl()) {
+			return nil, status.Error(codes.InvalidArgument, "Every original_url must be an absolute http(s) URL")
+		}
+		batch = append(batch, models.BatchURL{CorrelationID: item.GetCorrelationId(), OriginalURL: normalizeURL(item.GetOriginalUrl())})
+	}
+
+	ids, err := s.c.shortenBatch(ctx, batch)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	resp := &pb.BatchShortenResponse{Urls: make([]*pb.BatchShortURL, 0, len(batch))}
+	for _, item := range batch {
+		resp.Urls = append(resp.Urls, &pb.BatchShortURL{CorrelationId: item.CorrelationID, ShortUrl: ids[item.OriginalURL]})
+	}
+	return resp, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	pb "github.com/absurd678/skill/internal/proto"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpcClient serves the connection in process and dials it
+func grpcClient(t *testing.T, c *Connection) pb.ShortenerClient {
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer(c)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewShortenerClient(conn)
+}
+
+func Test_GRPCShorten(t *testing.T) {
+	store := storage.NewMemStorage(nil)
+	client := grpcClient(t, NewConnection(store))
+	ctx := context.Background()
+
+	resp, err := client.Shorten(ctx, &pb.ShortenRequest{OriginalUrl: "https://mai.ru"})
+	require.NoError(t, err)
+	require.False(t, resp.GetExisted())
+	original, ok := store.Get(ctx, resp.GetShortUrl())
+	require.True(t, ok)
+	require.Equal(t, "https://mai.ru", original)
+
+	again, err := client.Shorten(ctx, &pb.ShortenRequest{OriginalUrl: "https://mai.ru"})
+	require.NoError(t, err)
+	require.True(t, again.GetExisted())
+	require.Equal(t, resp.GetShortUrl(), again.GetShortUrl())
+
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{OriginalUrl: " "})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	for _, invalid := range []string{"javascript:alert(1)", "mai.ru"} {
+		_, err = client.Shorten(ctx, &pb.ShortenRequest{OriginalUrl: invalid})
+		require.Equal(t, codes.InvalidArgument, status.Code(err), invalid)
+	}
+	stats, err := store.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.URLs) // nothing stored
+}
+
+func Test_GRPCResolve(t *testing.T) {
+	store := storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru", "old": "https://practicum.net"})
+	require.NoError(t, store.Delete(context.Background(), []string{"old"}))
+	client := grpcClient(t, NewConnection(store))
+
+	tests := []struct {
+		Name     string
+		ShortURL string
+		WantCode codes.Code
+		WantURL  string
+	}{
+		{Name: "Found", ShortURL: "sharaga", WantCode: codes.OK, WantURL: "https://mai.ru"},
+		{Name: "Unknown", ShortURL: "test", WantCode: codes.NotFound},
+		{Name: "Deleted", ShortURL: "old", WantCode: codes.NotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp, err := client.Resolve(context.Background(), &pb.ResolveRequest{ShortUrl: tc.ShortURL})
+			require.Equal(t, tc.WantCode, status.Code(err))
+			require.Equal(t, tc.WantURL, resp.GetOriginalUrl())
+		})
+	}
+
+	// the storage failure isn't a missing url
+	client = grpcClient(t, NewConnection(failingStore{storage.NewMemStorage(nil)}))
+	_, err := client.Resolve(context.Background(), &pb.ResolveRequest{ShortUrl: "sharaga"})
+	require.Equal(t, codes.Internal, status.Code(err))
+}
+
+func Test_GRPCBatchShorten(t *testing.T) {
+	store := storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+	client := grpcClient(t, NewConnection(store))
+	ctx := context.Background()
+
+	resp, err := client.BatchShorten(ctx, &pb.BatchShortenRequest{Urls: []*pb.BatchURL{
+		{CorrelationId: "1", OriginalUrl: "https://mai.ru"},
+		{CorrelationId: "2", OriginalUrl: "https://practicum.net"},
+		{CorrelationId: "3", OriginalUrl: "https://practicum.net"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, resp.GetUrls(), 3)
+	require.Equal(t, "1", resp.GetUrls()[0].GetCorrelationId())
+	require.Equal(t, "sharaga", resp.GetUrls()[0].GetShortUrl())
+	require.Equal(t, resp.GetUrls()[1].GetShortUrl(), resp.GetUrls()[2].GetShortUrl())
+	original, ok := store.Get(ctx, resp.GetUrls()[1].GetShortUrl())
+	require.True(t, ok)
+	require.Equal(t, "https://practicum.net", original)
+
+	_, err = client.BatchShorten(ctx, &pb.BatchShortenRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.BatchShorten(ctx, &pb.BatchShortenRequest{Urls: []*pb.BatchURL{{CorrelationId: "1"}}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.BatchShorten(ctx, &pb.BatchShortenRequest{Urls: []*pb.BatchURL{{CorrelationId: "1", OriginalUrl: "mai.ru"}}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
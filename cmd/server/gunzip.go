@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/absurd678/skill/cmd/config"
+)
+
+// GunzipHandler answers the decompressed gzip body, to diagnose the client compression.
+// The body is the payload here, so it is sent without Content-Encoding.
+func (c *Connection) GunzipHandler(res http.ResponseWriter, req *http.Request) {
+	rgzip, err := newDecompress(req.Body)
+	if err != nil {
+		writeBodyError(res, err, "Invalid gzip body")
+		return
+	}
+	defer rgzip.Close()
+
+	decompressed, err := io.ReadAll(limitBody(res, rgzip, config.MaxBodySize))
+	if err != nil {
+		writeBodyError(res, err, "Invalid gzip body")
+		return
+	}
+	res.Header().Set("Content-Type", "application/octet-stream")
+	res.WriteHeader(http.StatusOK)
+	res.Write(decompressed)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GunzipHandler(t *testing.T) {
+	oldSubnet := config.TrustedSubnet
+	defer func() { config.TrustedSubnet = oldSubnet }()
+
+	payload := `{"url": "https://practicum.net"}`
+	gzipped := bytes.NewBuffer(nil)
+	writer := gzip.NewWriter(gzipped)
+	_, err := writer.Write([]byte(payload))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	tests := []struct {
+		Name     string
+		Subnet   string
+		Body     []byte
+		WantCode int
+		WantBody string
+	}{
+		{
+			Name:     "Decompressed",
+			Subnet:   "127.0.0.0/8",
+			Body:     gzipped.Bytes(),
+			WantCode: http.StatusOK,
+			WantBody: payload,
+		},
+		{
+			Name:     "Not gzip",
+			Subnet:   "127.0.0.0/8",
+			Body:     []byte(payload),
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "Not trusted",
+			Subnet:   "10.0.0.0/8",
+			Body:     gzipped.Bytes(),
+			WantCode: http.StatusForbidden,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			config.TrustedSubnet = tc.Subnet
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+			defer ts.Close()
+
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/api/internal/gunzip", body: bytes.NewReader(tc.Body)})
+			defer resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			if tc.WantCode == http.StatusOK {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Equal(t, tc.WantBody, string(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"strconv"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+)
+
+// hashID encodes the SHA-256 of the original url with the configured alphabet
+// and truncates it to the short id length, attempt > 0 rehashes after a collision
+func hashID(original string, attempt int) string {
+	data := original
+	if attempt > 0 {
+		data += "#" + strconv.Itoa(attempt)
+	}
+	sum := sha256.Sum256([]byte(data))
+
+	alphabet := config.ShortIDAlphabet
+	n := new(big.Int).SetBytes(sum[:])
+	base := big.NewInt(int64(len(alphabet)))
+	digit := new(big.Int)
+	b := make([]byte, config.ShortIDLength)
+	for i := range b {
+		n.DivMod(n, base, digit)
+		b[i] = alphabet[digit.Int64()]
+	}
+	return string(b)
+}
+
+// shortenHashed stores the original url under the id derived from its hash,
+// atomically like shorten. The id taken by another url with the same truncated
+// hash, or by this url deleted before, is rehashed.
+func (c *Connection) shortenHashed(ctx context.Context, original string) (id string, existed bool, err error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		id, existed, err = c.store.GetOrCreate(ctx, hashID(original, i), original)
+		if !errors.Is(err, storage.ErrIDTaken) {
+			return id, existed, err
+		}
+	}
+	return "", false, errIDsExhausted
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HashID(t *testing.T) {
+	id := hashID("https://practicum.net", 0)
+	require.Len(t, id, config.ShortIDLength)
+	require.Regexp(t, `^[a-zA-Z0-9]+$`, id)
+	require.Equal(t, id, hashID("https://practicum.net", 0))
+	require.NotEqual(t, id, hashID("https://mai.ru", 0))
+	require.NotEqual(t, id, hashID("https://practicum.net", 1))
+}
+
+func Test_PostHandlerDeterministic(t *testing.T) {
+	oldMode := config.ShortIDMode
+	config.ShortIDMode = "deterministic"
+	defer func() { config.ShortIDMode = oldMode }()
+
+	// the first hash of practicum.net is taken by another url
+	taken := hashID("https://practicum.net", 0)
+
+	tests := []struct {
+		Name     string
+		Body     string
+		WantCode int
+		WantID   string
+	}{
+		{
+			Name:     "Hashed id",
+			Body:     "https://mai.ru",
+			WantCode: http.StatusCreated,
+			WantID:   hashID("https://mai.ru", 0),
+		},
+		{
+			Name:     "Same url same id",
+			Body:     "https://mai.ru",
+			WantCode: http.StatusConflict,
+			WantID:   hashID("https://mai.ru", 0),
+		},
+		{
+			Name:     "Hash collision",
+			Body:     "https://practicum.net",
+			WantCode: http.StatusCreated,
+			WantID:   hashID("https://practicum.net", 1),
+		},
+	}
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{taken: "https://example.com"}))))
+	defer ts.Close()
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/", body: bytes.NewBufferString(tc.Body)})
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			require.Equal(t, "/"+tc.WantID, string(body))
+		})
+	}
+
+	// another server over a fresh storage gives the same id
+	other := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+	defer other.Close()
+	resp := testRequest(testRequestOptions{t: t, ts: other, method: http.MethodPost, path: "/api/shorten", body: bytes.NewBufferString(`{"url": "https://mai.ru"}`)})
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"result": "`+hashID("https://mai.ru", 0)+`"}`, string(body))
+}
+
+// A deleted url shortened again gets the next hash, not the deleted id
+func Test_ShortenHashedDeleted(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStorage(nil)
+	c := NewConnection(store)
+
+	first, existed, err := c.shortenHashed(ctx, "https://mai.ru")
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.NoError(t, store.Delete(ctx, []string{first}))
+
+	again, existed, err := c.shortenHashed(ctx, "https://mai.ru")
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.Equal(t, hashID("https://mai.ru", 1), again)
+	require.False(t, store.IsDeleted(ctx, again))
+	require.True(t, store.IsDeleted(ctx, first))
+}
+
+// The concurrent identical shortens get one id, created once
+func Test_ShortenHashedConcurrent(t *testing.T) {
+	store := storage.NewMemStorage(nil)
+	c := NewConnection(store)
+
+	const n = 50
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, existed, err := c.shortenHashed(context.Background(), "https://mai.ru")
+			assert.NoError(t, err)
+			assert.Equal(t, hashID("https://mai.ru", 0), id)
+			if !existed {
+				created.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), created.Load())
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/absurd678/skill/internal/storage"
+)
+
+const readyzPingTimeout = 2 * time.Second // the probe must not hang on a lost database
+
+// HealthzHandler answers 200 while the process is up
+func (c *Connection) HealthzHandler(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte(`{"status":"ok"}`))
+}
+
+// ReadyzHandler answers 200 once the storage is up and, for the database, reachable, else 503
+func (c *Connection) ReadyzHandler(res http.ResponseWriter, req *http.Request) {
+	if !c.ready.Load() {
+		writeJSONError(res, http.StatusServiceUnavailable, "Storage is not ready")
+		return
+	}
+	if pinger, ok := c.store.(storage.Pinger); ok {
+		ctx, cancel := context.WithTimeout(req.Context(), readyzPingTimeout)
+		defer cancel()
+		if err := pinger.Ping(ctx); err != nil {
+			logger.Sugar().Errorw("Storage ping", "Error", err)
+			writeJSONError(res, http.StatusServiceUnavailable, "Storage is unreachable")
+			return
+		}
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte(`{"status":"ok"}`))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// pingStorage is the memory storage behind a connection failing with pingErr
+type pingStorage struct {
+	*storage.MemStorage
+	pingErr error
+}
+
+func (ps *pingStorage) Ping(ctx context.Context) error {
+	return ps.pingErr
+}
+
+func Test_HealthzHandler(t *testing.T) {
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+	defer ts.Close()
+
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/healthz"})
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode) // even with the storage not ready
+}
+
+func Test_ReadyzHandler(t *testing.T) {
+	store := &pingStorage{MemStorage: storage.NewMemStorage(nil)}
+	c := NewConnection(store)
+	ts := httptest.NewServer(LaunchMyRouter(c))
+	defer ts.Close()
+
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/readyz"})
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode) // before the storage is up
+
+	c.ready.Store(true)
+	resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/readyz"})
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the lost database
+	store.pingErr = errors.New("connection refused")
+	resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/readyz"})
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// the shutdown
+	store.pingErr = nil
+	c.ready.Store(false)
+	resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/readyz"})
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/absurd678/skill/internal/models"
+	"github.com/absurd678/skill/internal/storage"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+)
+
+// replayedHeaders are set by the handlers, the others belong to the middlewares
+// and the current request (compression, CORS, request id)
+var replayedHeaders = []string{"Content-Type", shortIDHeader}
+
+// recordingWriter keeps a copy of the answer for the cache
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// idempotent handles the first POST with an Idempotency-Key and replays its
+// answer to the repeated ones, so a retried request creates nothing twice.
+// The key belongs to the method, path and body it came with first. The
+// failed (5xx) and cancelled requests aren't kept, their retries run again.
+func (c *Connection) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(idempotencyKeyHeader)
+		if key == "" || c.idempotency == nil {
+			next.ServeHTTP(res, req)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeJSONError(res, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeBodyError(res, err, "Invalid body")
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := sha256.Sum256(body)
+		key = req.Method + " " + req.URL.Path + " " + key
+
+		cached, err := c.idempotency.Begin(req.Context(), key, hex.EncodeToString(fingerprint[:]))
+		if contextDone(res, req) {
+			return
+		}
+		switch {
+		case errors.Is(err, storage.ErrKeyInProgress):
+			writeJSONError(res, http.StatusConflict, "A request with this Idempotency-Key is in progress")
+			return
+		case errors.Is(err, storage.ErrKeyReused):
+			writeJSONError(res, http.StatusUnprocessableEntity, "Idempotency-Key is used by another request")
+			return
+		case err != nil:
+			writeJSONError(res, http.StatusInternalServerError, "Can't check Idempotency-Key")
+			return
+		case cached != nil:
+			for name, value := range cached.Header {
+				res.Header().Set(name, value)
+			}
+			res.Header().Set("Idempotent-Replayed", "true")
+			res.WriteHeader(cached.Status)
+			res.Write(cached.Body)
+			return
+		}
+
+		// a panicking handler leaves the key too, recoverPanic answers it further out
+		finished := false
+		defer func() {
+			if !finished {
+				c.idempotency.Abort(key)
+			}
+		}()
+		rw := &recordingWriter{ResponseWriter: res}
+		next.ServeHTTP(rw, req)
+		if rw.status == 0 || rw.status >= http.StatusInternalServerError || req.Context().Err() != nil {
+			return
+		}
+		header := map[string]string{}
+		for _, name := range replayedHeaders {
+			if value := res.Header().Get(name); value != "" {
+				header[name] = value
+			}
+		}
+		c.idempotency.Finish(key, models.CachedResponse{Status: rw.status, Header: header, Body: rw.body.Bytes()})
+		finished = true
+	})
+}
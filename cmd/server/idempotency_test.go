@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Idempotency(t *testing.T) {
+	type answer struct {
+		Code     int
+		Body     string
+		ShortID  string
+		Replayed string
+	}
+	tests := []struct {
+		Name      string
+		Path      string
+		Bodies    []string // sent one by one
+		Keys      []string
+		Want      []answer
+		WantSaved int
+	}{
+		{
+			Name:   "Replayed",
+			Path:   "/",
+			Bodies: []string{"https://practicum.net", "https://practicum.net", "https://practicum.net"},
+			Keys:   []string{"key-1", "key-1", "key-1"},
+			Want: []answer{
+				{Code: http.StatusCreated, Body: "/id1", ShortID: "id1"},
+				{Code: http.StatusCreated, Body: "/id1", ShortID: "id1", Replayed: "true"},
+				{Code: http.StatusCreated, Body: "/id1", ShortID: "id1", Replayed: "true"},
+			},
+			WantSaved: 1,
+		},
+		{
+			Name:   "Without a key",
+			Path:   "/",
+			Bodies: []string{"https://practicum.net", "https://practicum.net"},
+			Keys:   []string{"", ""},
+			Want: []answer{
+				{Code: http.StatusCreated, Body: "/id1", ShortID: "id1"},
+				{Code: http.StatusConflict, Body: "/id1", ShortID: "id1"},
+			},
+			WantSaved: 1,
+		},
+		{
+			Name:   "Another key",
+			Path:   "/",
+			Bodies: []string{"https://practicum.net", "https://mai.ru"},
+			Keys:   []string{"key-1", "key-2"},
+			Want: []answer{
+				{Code: http.StatusCreated, Body: "/id1", ShortID: "id1"},
+				{Code: http.StatusCreated, Body: "/id2", ShortID: "id2"},
+			},
+			WantSaved: 2,
+		},
+		{
+			Name:   "Key reused with another body",
+			Path:   "/",
+			Bodies: []string{"https://practicum.net", "https://mai.ru"},
+			Keys:   []string{"key-1", "key-1"},
+			Want: []answer{
+				{Code: http.StatusCreated, Body: "/id1", ShortID: "id1"},
+				{Code: http.StatusUnprocessableEntity, Body: `{"error":"Idempotency-Key is used by another request"}`},
+			},
+			WantSaved: 1,
+		},
+		{
+			Name:   "Batch",
+			Path:   "/api/shorten/batch",
+			Bodies: []string{`[{"correlation_id": "1", "original_url": "https://practicum.net"}]`, `[{"correlation_id": "1", "original_url": "https://practicum.net"}]`},
+			Keys:   []string{"key-1", "key-1"},
+			Want: []answer{
+				{Code: http.StatusCreated, Body: "[\n {\n  \"correlation_id\": \"1\",\n  \"short_url\": \"id1\"\n }\n]"},
+				{Code: http.StatusCreated, Body: "[\n {\n  \"correlation_id\": \"1\",\n  \"short_url\": \"id1\"\n }\n]", Replayed: "true"},
+			},
+			WantSaved: 1,
+		},
+		{
+			Name:   "Too long key",
+			Path:   "/",
+			Bodies: []string{"https://practicum.net"},
+			Keys:   []string{string(bytes.Repeat([]byte("k"), maxIdempotencyKeyLen+1))},
+			Want: []answer{
+				{Code: http.StatusBadRequest, Body: `{"error":"Idempotency-Key is too long"}`},
+			},
+			WantSaved: 0,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			calls := 0
+			oldShortID := newShortID
+			newShortID = func() string {
+				calls++
+				return "id" + strconv.Itoa(calls)
+			}
+			defer func() { newShortID = oldShortID }()
+
+			store := storage.NewMemStorage(nil)
+			c := NewConnection(store)
+			c.idempotency = storage.NewIdempotencyCache(time.Hour)
+			ts := httptest.NewServer(LaunchMyRouter(c))
+			defer ts.Close()
+
+			for i, body := range tc.Bodies {
+				req, err := http.NewRequest(http.MethodPost, ts.URL+tc.Path, bytes.NewBufferString(body))
+				require.NoError(t, err)
+				if tc.Keys[i] != "" {
+					req.Header.Set("Idempotency-Key", tc.Keys[i])
+				}
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				respBody, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				require.NoError(t, err)
+
+				require.Equal(t, tc.Want[i].Code, resp.StatusCode, "request %d", i)
+				require.Equal(t, tc.Want[i].Body, string(respBody), "request %d", i)
+				require.Equal(t, tc.Want[i].ShortID, resp.Header.Get("X-Short-Id"), "request %d", i)
+				require.Equal(t, tc.Want[i].Replayed, resp.Header.Get("Idempotent-Replayed"), "request %d", i)
+			}
+			stats, err := store.Stats(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, tc.WantSaved, stats.URLs)
+		})
+	}
+}
+
+// panickingStore panics on the first shorten, like a handler bug
+type panickingStore struct {
+	*storage.MemStorage
+	panicked bool
+}
+
+func (ps *panickingStore) GetOrCreate(ctx context.Context, shortURL, originalURL string) (string, bool, error) {
+	if !ps.panicked {
+		ps.panicked = true
+		panic("shorten bug")
+	}
+	return ps.MemStorage.GetOrCreate(ctx, shortURL, originalURL)
+}
+
+// The key of the panicked request isn't left in progress, its retry runs again
+func Test_IdempotencyPanic(t *testing.T) {
+	observeLogs(t) // the panic is logged
+	c := NewConnection(&panickingStore{MemStorage: storage.NewMemStorage(nil)})
+	c.idempotency = storage.NewIdempotencyCache(time.Hour)
+	ts := httptest.NewServer(LaunchMyRouter(c))
+	defer ts.Close()
+
+	for _, wantCode := range []int{http.StatusInternalServerError, http.StatusCreated} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/", bytes.NewBufferString("https://practicum.net"))
+		require.NoError(t, err)
+		req.Header.Set("Idempotency-Key", "key-1")
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, wantCode, resp.StatusCode)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+
+	"github.com/absurd678/skill/cmd/config"
+)
+
+// socketMode lets the owner and the group of the server connect to its Unix socket
+const socketMode fs.FileMode = 0660
+
+// listen opens the server address, the Unix socket left by a previous run is
+// removed first, any other file at its path is an error
+func listen(addr config.FlagRunAddr) (net.Listener, error) {
+	if addr.Network() != "unix" {
+		return net.Listen("tcp", addr.Address())
+	}
+	info, err := os.Lstat(addr.Socket)
+	switch {
+	case err == nil && info.Mode()&fs.ModeSocket == 0:
+		return nil, fmt.Errorf("%s exists and is not a socket", addr.Socket)
+	case err == nil:
+		if err = os.Remove(addr.Socket); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", addr.Socket)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(addr.Socket, socketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil // the socket file is removed by Close
+}
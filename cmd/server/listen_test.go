@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// The server answers over the Unix socket, the stale socket of a previous run is replaced
+func Test_ListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shortener.sock")
+	var addr config.FlagRunAddr
+	require.NoError(t, addr.Set("unix:"+path))
+
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false) // left behind like after a crash
+	require.NoError(t, stale.Close())
+
+	listener, err := listen(addr)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, socketMode, info.Mode().Perm())
+
+	server := &http.Server{Handler: LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})))}
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get("http://shortener/api/expand/sharaga")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "https://mai.ru")
+}
+
+// A regular file at the socket path is kept, the server doesn't start
+func Test_ListenUnixNotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shortener.sock")
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0644))
+	var addr config.FlagRunAddr
+	require.NoError(t, addr.Set("unix:"+path))
+
+	_, err := listen(addr)
+	require.Error(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Zero(t, info.Mode()&fs.ModeSocket)
+}
+
+func Test_ListenTCP(t *testing.T) {
+	var addr config.FlagRunAddr
+	require.NoError(t, addr.Set("127.0.0.1:0"))
+	listener, err := listen(addr)
+	require.NoError(t, err)
+	defer listener.Close()
+	require.Equal(t, "tcp", listener.Addr().Network())
+}
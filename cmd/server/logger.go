@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logger is built from the config in main, silent until then (and in tests)
+var logger = zap.NewNop()
+
+// newLogger builds the development (colored console) or production (JSON) logger
+// writing the entries of the level and above to the sink, stderr when it's nil
+func newLogger(mode, level string, sink io.Writer) (*zap.Logger, error) {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg zap.Config
+	switch mode {
+	case "development":
+		cfg = zap.NewDevelopmentConfig()
+	case "production":
+		cfg = zap.NewProductionConfig()
+	default:
+		return nil, fmt.Errorf("unknown logger mode: %s", mode)
+	}
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	if sink == nil {
+		return cfg.Build()
+	}
+
+	// the same encoding and level, only the output is replaced
+	var encoder zapcore.Encoder
+	if cfg.Encoding == "json" {
+		encoder = zapcore.NewJSONEncoder(cfg.EncoderConfig)
+	} else {
+		encoder = zapcore.NewConsoleEncoder(cfg.EncoderConfig)
+	}
+	core := zapcore.NewCore(encoder, zapcore.AddSync(sink), cfg.Level)
+	return cfg.Build(zap.WrapCore(func(zapcore.Core) zapcore.Core { return core }))
+}
+
+// newLogFile opens the log file lazily, rotating it at maxSize megabytes
+// and keeping maxBackups old files (0 - all of them)
+func newLogFile(path string, maxSize, maxBackups int) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+	}
+}
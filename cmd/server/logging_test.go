@@ -0,0 +1,234 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// observeLogs makes the middleware log into the returned observer
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.DebugLevel)
+	oldLogger := logger
+	logger = zap.New(core)
+	t.Cleanup(func() { logger = oldLogger })
+	return logs
+}
+
+func Test_LogRoutePattern(t *testing.T) {
+	oldSubnet := config.TrustedSubnet
+	config.TrustedSubnet = "127.0.0.0/8"
+	defer func() { config.TrustedSubnet = oldSubnet }()
+
+	tests := []struct {
+		Name        string
+		Path        string
+		WantPattern string
+	}{
+		{
+			Name:        "Redirect",
+			Path:        "/sharaga",
+			WantPattern: "/{id}",
+		},
+		{
+			Name:        "Stats",
+			Path:        "/api/internal/stats",
+			WantPattern: "/api/internal/stats",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			logs := observeLogs(t)
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+			defer ts.Close()
+
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: tc.Path})
+			resp.Body.Close()
+
+			responses := logs.FilterMessage("Response parameters").All()
+			require.Len(t, responses, 1)
+			require.Equal(t, tc.WantPattern, responses[0].ContextMap()["Route"])
+		})
+	}
+}
+
+// The access log keeps the status the client got: 404 for an unknown id,
+// 400 only for the path that isn't an id at all
+func Test_LogStatusCode(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Path     string
+		WantCode int
+	}{
+		{Name: "Known id", Path: "/sharaga", WantCode: http.StatusTemporaryRedirect},
+		{Name: "Unknown id", Path: "/test", WantCode: http.StatusNotFound},
+		{Name: "Malformed id", Path: "/sha_raga", WantCode: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			logs := observeLogs(t)
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+			defer ts.Close()
+
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: tc.Path})
+			resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+
+			responses := logs.FilterMessage("Response parameters").All()
+			require.Len(t, responses, 1)
+			require.Equal(t, int64(tc.WantCode), responses[0].ContextMap()["Status Code"])
+		})
+	}
+}
+
+func Test_NewLogger(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Mode        string
+		Level       string
+		WantErr     bool
+		WantEnabled zapcore.Level // the lowest enabled level
+	}{
+		{
+			Name:        "Development debug",
+			Mode:        "development",
+			Level:       "debug",
+			WantEnabled: zapcore.DebugLevel,
+		},
+		{
+			Name:        "Production warn",
+			Mode:        "production",
+			Level:       "warn",
+			WantEnabled: zapcore.WarnLevel,
+		},
+		{
+			Name:    "Unknown mode",
+			Mode:    "verbose",
+			Level:   "info",
+			WantErr: true,
+		},
+		{
+			Name:    "Unknown level",
+			Mode:    "production",
+			Level:   "loud",
+			WantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			l, err := newLogger(tc.Mode, tc.Level, nil)
+			if tc.WantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, l.Core().Enabled(tc.WantEnabled))
+			if tc.WantEnabled > zapcore.DebugLevel {
+				require.False(t, l.Core().Enabled(tc.WantEnabled-1))
+			}
+		})
+	}
+}
+
+// With the log file set the access log goes to the file
+func Test_LogFile(t *testing.T) {
+	for _, mode := range []string{"development", "production"} {
+		t.Run(mode, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "access.log")
+			logFile := newLogFile(path, 1, 1)
+			defer logFile.Close()
+
+			l, err := newLogger(mode, "info", logFile)
+			require.NoError(t, err)
+			oldLogger := logger
+			logger = l
+			defer func() { logger = oldLogger }()
+
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+			defer ts.Close()
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/sharaga"})
+			require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+			require.NoError(t, logger.Sync())
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.Contains(t, string(data), "/sharaga")
+			require.Contains(t, string(data), "307")
+		})
+	}
+}
+
+func Test_NoAccessLog(t *testing.T) {
+	tests := []struct {
+		Name        string
+		NoAccessLog bool
+		WantEntries int
+	}{
+		{Name: "Access log", NoAccessLog: false, WantEntries: 2},
+		{Name: "No access log", NoAccessLog: true, WantEntries: 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			oldNoAccessLog := config.NoAccessLog
+			config.NoAccessLog = tc.NoAccessLog
+			defer func() { config.NoAccessLog = oldNoAccessLog }()
+
+			logs := observeLogs(t)
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+			defer ts.Close()
+
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/sharaga"})
+			resp.Body.Close()
+			require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode) // routing still works
+			require.Equal(t, tc.WantEntries, logs.Len())
+		})
+	}
+}
+
+func Test_LogRequestID(t *testing.T) {
+	tests := []struct {
+		Name      string
+		RequestID string // sent by the client
+	}{
+		{Name: "Generated"},
+		{Name: "From the client", RequestID: "client-42"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			logs := observeLogs(t)
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/sharaga", nil)
+			require.NoError(t, err)
+			if tc.RequestID != "" {
+				req.Header.Set("X-Request-Id", tc.RequestID)
+			}
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			requestID := resp.Header.Get("X-Request-ID")
+			require.NotEmpty(t, requestID)
+			if tc.RequestID != "" {
+				require.Equal(t, tc.RequestID, requestID)
+			}
+			requests := logs.FilterMessage("Request parameters").All()
+			responses := logs.FilterMessage("Response parameters").All()
+			require.Len(t, requests, 1)
+			require.Len(t, responses, 1)
+			require.Equal(t, requestID, requests[0].ContextMap()["Request ID"])
+			require.Equal(t, requestID, responses[0].ContextMap()["Request ID"])
+		})
+	}
+}
@@ -2,31 +2,61 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"math/rand"
+	"mime"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/absurd678/skill/cmd/config"
 	"github.com/absurd678/skill/internal/models"
+	"github.com/absurd678/skill/internal/storage"
 	"github.com/go-chi/chi/v5"
-	"go.uber.org/zap"
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
 )
 
 var mapURLmain = map[string]string{
 	"sharaga": "https://mai.ru",
 }
 
-const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
-const shortURLsize int = 10
+const maxIDAttempts int = 10 // how many times to regenerate the colliding id
+
+const shutdownTimeout = 10 * time.Second // for the requests in flight and the queued deletes
+
+var errIDsExhausted = errors.New("no unused short id found")
+
+// shortIDHeader keeps the bare id of the shorten response
+const shortIDHeader = "X-Short-Id"
+
+// requestIDHeader echoes the id of the request found in the logs,
+// the client may send its own in X-Request-Id
+const requestIDHeader = "X-Request-ID"
+
+// newShortID generates the candidate ids, replaced in tests
+var newShortID = func() string { return RandString(config.ShortIDLength) }
 
 // ----------------------STRUCTURES----------------------------
 type (
 	Connection struct {
-		mapURL map[string]string
+		store    storage.Storage
+		icons    *FaviconCache
+		banner   *Banner
+		watchdog *Watchdog // nil - the liveness isn't checked
+		deleter  *Deleter  // nil - the urls are deleted in the request
+		// nil - the Idempotency-Key is ignored
+		idempotency *storage.IdempotencyCache
+		ready       atomic.Bool // the storage is up, cleared at the shutdown
 	}
 
 	// Logging
@@ -36,9 +66,14 @@ type (
 	}
 
 	ResLogOrCompress struct { // to log response data
-		res  http.ResponseWriter
-		data *LogData
-		gz   *gzip.Writer // compress data
+		res    http.ResponseWriter
+		data   *LogData
+		coding string         // the accepted Content-Encoding, "" - no compression
+		enc    io.WriteCloser // compress data: gzip, br or deflate, nil until the answer has a body
+		// the status and the start of the body held until the body is large
+		// enough to compress, 0 - nothing held
+		pending int
+		buf     []byte
 	}
 	// Logging
 
@@ -55,9 +90,19 @@ func (lc *ResLogOrCompress) Write(b []byte) (int, error) {
 	var size int
 	var err error
 
-	if lc.gz != nil { // if the compression initiated
-		size, err = lc.gz.Write(b) // compress first
-	} else {
+	if lc.data.code == 0 { // Write without WriteHeader means 200
+		lc.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case lc.pending != 0: // not sure yet it is worth compressing
+		lc.buf = append(lc.buf, b...)
+		size = len(b)
+		if len(lc.buf) >= config.CompressMinSize {
+			err = lc.send(true)
+		}
+	case lc.enc != nil: // if the compression initiated
+		size, err = lc.enc.Write(b) // compress first
+	default:
 		size, err = lc.res.Write(b) // no compression
 	}
 
@@ -69,14 +114,82 @@ func (lc *ResLogOrCompress) Write(b []byte) (int, error) {
 }
 
 func (lc *ResLogOrCompress) WriteHeader(StatusCode int) {
+	if lc.pending != 0 { // the first status is kept
+		return
+	}
+	if lc.data.code == 0 && lc.compressible(StatusCode) {
+		lc.pending = StatusCode
+		lc.data.code = StatusCode
+		return
+	}
 	lc.res.WriteHeader(StatusCode)
 	lc.data.code = StatusCode
 }
 
+// compressible reports whether the answer may get a compressed body: the
+// answers without a body are not compressed, as well as the one the handler
+// encoded itself
+func (lc *ResLogOrCompress) compressible(StatusCode int) bool {
+	if lc.coding == "" || StatusCode == http.StatusNoContent || StatusCode == http.StatusNotModified {
+		return false
+	}
+	return lc.res.Header().Get("Content-Encoding") == "" // no double encoding
+}
+
+// send writes the held status and body, compressed or not. An encoder that
+// can't be created leaves the answer uncompressed.
+func (lc *ResLogOrCompress) send(compress bool) error {
+	status, buf := lc.pending, lc.buf
+	lc.pending, lc.buf = 0, nil
+	if compress {
+		enc, err := compressors[lc.coding](lc.res)
+		if err != nil {
+			logger.Sugar().Errorf("Error creating %s writer: %s", lc.coding, err)
+		} else {
+			lc.res.Header().Set("Content-Encoding", lc.coding)
+			lc.res.Header().Del("Content-Length") // of the uncompressed body
+			lc.enc = enc
+		}
+	}
+	lc.res.WriteHeader(status)
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if lc.enc != nil {
+		_, err = lc.enc.Write(buf)
+	} else {
+		_, err = lc.res.Write(buf)
+	}
+	return err
+}
+
+// Close sends the held small answer uncompressed and flushes the compressed
+// stream, even the one the handler gave up writing halfway, it does nothing
+// the second time
+func (lc *ResLogOrCompress) Close() error {
+	if lc.pending != 0 {
+		if err := lc.send(false); err != nil {
+			return err
+		}
+	}
+	if lc.enc == nil {
+		return nil
+	}
+	err := lc.enc.Close()
+	lc.enc = nil
+	return err
+}
+
 func (lc *ResLogOrCompress) Header() http.Header {
 	return lc.res.Header()
 }
 
+// Unwrap lets http.ResponseController reach the connection, e.g. for its deadlines
+func (lc *ResLogOrCompress) Unwrap() http.ResponseWriter {
+	return lc.res
+}
+
 //-----------------------logResponse------------------------------
 
 // ------------------------Decompress-----------------------------
@@ -101,167 +214,598 @@ func (d *Decompress) Close() error {
 
 // ------------------------Decompress-----------------------------
 
-// RandString generates a random string with the given length
+// RandString generates a random string with the given length from the configured alphabet
 func RandString(n int) string {
-	// rand.Seed is deprecated, use NewSource instead :D
-	r := rand.New(rand.NewSource(time.Now().Unix()))
+	// the top-level source is seeded once and safe for concurrent use
+	letterBytes := config.ShortIDAlphabet
 	b := make([]byte, n)
 	for i := range b {
-		b[i] = letterBytes[r.Intn(len(letterBytes))]
+		b[i] = letterBytes[rand.Intn(len(letterBytes))]
 	}
 	return string(b)
 }
 
 // ------------------------Connection-----------------------------
+func NewConnection(store storage.Storage) *Connection {
+	return &Connection{store: store, icons: newFaviconCache(), banner: &Banner{}}
+}
+
+// uniqueID generates ids until the one not in the storage is found
+func (c *Connection) uniqueID(ctx context.Context) (string, error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		id, err := c.candidateID(ctx)
+		if err != nil {
+			return "", err
+		}
+		if !c.store.Exists(ctx, id) {
+			return id, ctx.Err() // cancelled ctx finds nothing
+		}
+	}
+	return "", errIDsExhausted
+}
+
+// shorten stores the normalized original url under a new id,
+// an already shortened original keeps its id and existed is true
+func (c *Connection) shorten(ctx context.Context, original string) (id string, existed bool, err error) {
+	original = normalizeURL(original)
+	if config.ShortIDMode == "deterministic" {
+		return c.shortenHashed(ctx, original)
+	}
+	if config.ShortIDMode == "counter" { // the shortened originals don't use up the counter
+		if id, ok := c.store.GetByOriginal(ctx, original); ok {
+			return id, true, nil
+		}
+	}
+	// the lookup and the save are atomic so concurrent identical originals get one id
+	for i := 0; i < maxIDAttempts; i++ {
+		if id, err = c.candidateID(ctx); err != nil {
+			return "", false, err
+		}
+		id, existed, err = c.store.GetOrCreate(ctx, id, original)
+		if !errors.Is(err, storage.ErrIDTaken) {
+			return id, existed, err
+		}
+	}
+	return "", false, errIDsExhausted
+}
+
+// writeShortenError answers 500 for the shorten failure
+func writeShortenError(res http.ResponseWriter, err error) {
+	if errors.Is(err, errIDsExhausted) {
+		writeJSONError(res, http.StatusInternalServerError, "Can't generate short URL")
+		return
+	}
+	logger.Sugar().Errorw("Can't save short URL", "Error", err)
+	writeJSONError(res, http.StatusInternalServerError, "Can't save short URL")
+}
+
+// writeStorageError answers 500 for the failed storage read, the cause is only logged
+func writeStorageError(res http.ResponseWriter, err error) {
+	logger.Sugar().Errorw("Storage error", "Error", err)
+	writeJSONError(res, http.StatusInternalServerError, "Storage error")
+}
+
 func (c *Connection) GetHandler(res http.ResponseWriter, req *http.Request) {
 	// take /{id} and search for value in the map
 	shortURL := chi.URLParam(req, "id")
-	original, ok := c.mapURL[shortURL]
-	if !ok {
-		res.WriteHeader(http.StatusBadRequest) // DOESN'T WORK to fill code field for logResponse
-		res.Write([]byte("Invalid URL for GET"))
+	original, err := c.store.Lookup(req.Context(), shortURL)
+	if contextDone(res, req) {
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		if config.NotFoundRedirectURL != "" {
+			res.Header().Set("Location", config.NotFoundRedirectURL)
+			res.WriteHeader(http.StatusFound)
+			return
+		}
+		writeJSONError(res, http.StatusNotFound, "Unknown short URL")
 		return
 	}
+	if err != nil {
+		writeStorageError(res, err)
+		return
+	}
+	if c.store.IsDeleted(req.Context(), shortURL) {
+		writeJSONError(res, http.StatusGone, "Short URL is deleted")
+		return
+	}
+	status := config.RedirectStatus
+	if targets, weighted := c.store.GetTargets(req.Context(), shortURL); weighted {
+		original = pickTarget(targets)
+		status = http.StatusTemporaryRedirect // the next target may differ, not to be cached
+	}
 
-	// Add the Location header with original URL
-	res.Header().Add("Location", original) // No location actually sent. However the header is added.
-	res.WriteHeader(http.StatusTemporaryRedirect)
-	res.Write([]byte(""))
+	// The headers must be set before WriteHeader, no body for the redirect
+	if notice := c.banner.Get(); notice != "" {
+		res.Header().Set("X-Shortener-Notice", notice)
+	}
+	if req.Method == http.MethodGet { // HEAD only checks the link
+		if err := c.store.Hit(req.Context(), shortURL); err != nil {
+			logger.Sugar().Errorw("Hit not counted", "Short URL", shortURL, "Error", err)
+		}
+	}
+	res.Header().Set("Location", original)
+	res.WriteHeader(status)
+}
+
+// plainBody reports whether the body is sent as text/plain or without a type
+func plainBody(req *http.Request) bool {
+	contentType := req.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/plain"
 }
 
 func (c *Connection) PostHandler(res http.ResponseWriter, req *http.Request) {
-	// Get the URL from the body (and the new id also) like this: localhost:8080 -d https://example
+	// the JSON body would be saved as the url itself
+	if !plainBody(req) {
+		writeJSONError(res, http.StatusUnsupportedMediaType, "Content-Type must be text/plain, send JSON to /api/shorten")
+		return
+	}
+	// Get the URL from the body (and the new id also) like this: curl -H 'Content-Type: text/plain' -d https://example localhost:8080
 	original, err := io.ReadAll(req.Body)
 	if err != nil {
-		res.WriteHeader(http.StatusBadRequest) // to fill code field for logResponse
-		res.Write([]byte("Invalid URL for POST"))
+		writeBodyError(res, err, "Invalid URL for POST")
+		return
+	}
+	// curl -H 'Content-Type: text/plain' -d and the editors add a trailing newline, a blank body would make a useless link
+	trimmed := strings.TrimSpace(string(original))
+	if trimmed == "" {
+		writeJSONError(res, http.StatusBadRequest, "The body must not be empty")
+		return
+	}
+	if !validURL(trimmed) {
+		writeJSONError(res, http.StatusBadRequest, "The body must be an absolute http(s) URL")
+		return
+	}
+	id, existed, err := c.shorten(req.Context(), trimmed)
+	if contextDone(res, req) {
+		return
+	}
+	if err != nil {
+		writeShortenError(res, err)
 		return
 	}
-	// get the new id from the b flag
-	c.mapURL[config.UrlID] = string(original)
 
-	res.WriteHeader(http.StatusCreated)
+	if config.PlainContentType != "" {
+		res.Header().Set("Content-Type", config.PlainContentType)
+	}
+	res.Header().Set(shortIDHeader, id)
+	if existed { // the same short url as before
+		res.WriteHeader(http.StatusConflict)
+	} else {
+		res.WriteHeader(http.StatusCreated)
+	}
 	// Body answer: localhost:8080/{id}
-	res.Write([]byte(req.URL.Path + config.UrlID))
+	res.Write([]byte(req.URL.Path + id))
 }
 
 func (c *Connection) PostHandlerJSON(res http.ResponseWriter, req *http.Request) {
 	// get json: {"url": "some_url"}
 	// return json: {"result": "short_url"}
 	var some_url models.SomeURL
-	var short_url models.ShortURL
-	var buff []byte
 	var err error
 
-	if err = json.NewDecoder(req.Body).Decode(&some_url); err != nil {
-		res.WriteHeader(http.StatusBadRequest)
+	if err = decodeJSON(req.Body, &some_url); err != nil {
+		writeBodyError(res, err, "Invalid JSON")
+		return
+	}
+	if strings.TrimSpace(some_url.URL) == "" { // missing or empty, would redirect nowhere
+		writeJSONError(res, http.StatusBadRequest, "The url field must not be empty")
+		return
+	}
+	if !validURL(some_url.URL) { // e.g. javascript:, it would be redirected to
+		writeJSONError(res, http.StatusBadRequest, "The url field must be an absolute http(s) URL")
 		return
 	}
-	short_url = models.ShortURL{URL: config.UrlID}
-	c.mapURL[short_url.URL] = some_url.URL
-	res.WriteHeader(http.StatusCreated)
-	if buff, err = json.MarshalIndent(short_url, "", " "); err != nil {
-		res.WriteHeader(http.StatusBadRequest)
-		res.Write([]byte("Unmarshable data"))
+	id, existed, err := c.shorten(req.Context(), some_url.URL)
+	if contextDone(res, req) {
 		return
 	}
+	if err != nil {
+		writeShortenError(res, err)
+		return
+	}
+	writeShortURL(res, id, existed)
+}
+
+// writeShortURL answers {"result": "id"}, 201 for the new id and 409 for the existing one
+func writeShortURL(res http.ResponseWriter, id string, existed bool) {
+	buff, err := json.MarshalIndent(models.ShortURL{URL: id}, "", " ")
+	if err != nil {
+		writeJSONError(res, http.StatusBadRequest, "Unmarshable data")
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set(shortIDHeader, id)
+	if existed { // the same short url as before
+		res.WriteHeader(http.StatusConflict)
+	} else {
+		res.WriteHeader(http.StatusCreated)
+	}
 	res.Write(buff)
 }
 
 // ------------------------Connection-----------------------------
 
+// contextDone answers 503 when the request was cancelled or timed out
+func contextDone(res http.ResponseWriter, req *http.Request) bool {
+	if req.Context().Err() == nil {
+		return false
+	}
+	writeJSONError(res, http.StatusServiceUnavailable, "Request cancelled or timed out")
+	return true
+}
+
+// expandStreamPath is the NDJSON stream lookup, too large for the limits of the other requests
+const expandStreamPath = "/api/batch/expand-stream"
+
+// isStream reports whether the request is the stream lookup, checked by the path as
+// the limits are applied before the routing
+func isStream(req *http.Request) bool {
+	return req.URL.Path == expandStreamPath
+}
+
+// requestTimeout limits every request with the configured timeout, the stream lookup with its own
+func requestTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		timeout := config.RequestTimeout
+		if isStream(req) {
+			timeout = config.StreamTimeout
+		}
+		if timeout <= 0 {
+			next.ServeHTTP(res, req)
+			return
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(res, req.WithContext(ctx))
+	})
+}
+
+// bodyLimit is the body cap of the request, config.StreamMaxBodySize for the stream lookup
+func bodyLimit(req *http.Request) int64 {
+	if isStream(req) {
+		return config.StreamMaxBodySize
+	}
+	return config.MaxBodySize
+}
+
+// limitBody caps the body at the limit (0 - no limit), reading past it fails
+func limitBody(res http.ResponseWriter, body io.ReadCloser, limit int64) io.ReadCloser {
+	if limit <= 0 {
+		return body
+	}
+	return http.MaxBytesReader(res, body, limit)
+}
+
+// bodyTooLarge reports whether the body read failed on the limitBody cap
+func bodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+var errTrailingData = errors.New("data after the JSON value")
+
+// decodeJSON strictly decodes the body holding a single JSON value: the
+// unknown fields and anything but spaces after the value are errors
+func decodeJSON(body io.Reader, v any) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	_, err := decoder.Token()
+	switch {
+	case errors.Is(err, io.EOF):
+		return nil
+	case bodyTooLarge(err):
+		return err
+	}
+	return errTrailingData
+}
+
+// writeBodyError answers 413 to the body over the limit and 400 with the message otherwise
+func writeBodyError(res http.ResponseWriter, err error, message string) {
+	if bodyTooLarge(err) {
+		writeJSONError(res, http.StatusRequestEntityTooLarge, "Request body is too large")
+		return
+	}
+	writeJSONError(res, http.StatusBadRequest, message)
+}
+
+// writeJSONError answers {"error": "message"} with the status
+func writeJSONError(res http.ResponseWriter, status int, message string) {
+	buff, err := json.Marshal(models.ErrorResponse{Error: message})
+	if err != nil {
+		http.Error(res, message, status)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	res.Write(buff)
+}
+
+// routePattern is the chi pattern matched by the request like /{id}, known after the routing
+func routePattern(req *http.Request) string {
+	if rctx := chi.RouteContext(req.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
+
 func checkURL(next http.Handler) http.Handler { // to avoid paths like localhost:8080/{id}/extrapath
 
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 
 		// compression variables
 
-		var wgzip *gzip.Writer
 		var rgzip *Decompress
 
-		// Logging setup
-		middlewareLogger, err := zap.NewDevelopment()
-		if err != nil {
-			http.Error(res, "Logger error", http.StatusInternalServerError)
+		// Logging setup, the request id set by middleware.RequestID ties the entries together
+		requestID := middleware.GetReqID(req.Context())
+		res.Header().Set(requestIDHeader, requestID)
+		sugarLogger := logger.Sugar().With("Request ID", requestID) // for JSON-like messages
+		// Logging request, the watchdog probes aren't clients
+		accessLog := !config.NoAccessLog && !isWatchdogProbe(req)
+		if accessLog {
+			sugarLogger.Infow("Request parameters",
+				"URI", req.RequestURI,
+				"Method", req.Method,
+			)
 		}
-		sugarLogger := middlewareLogger.Sugar() // for JSON-like messages
-		// Logging request
-		sugarLogger.Infow("Request parameters",
-			"URI", req.RequestURI,
-			"Method", req.Method,
-		)
-
-		// Check Accept-Encoding
-		if strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
-			var err error
-			wgzip, err = gzip.NewWriterLevel(res, gzip.BestSpeed)
-			res.Header().Set("Content-Encoding", "gzip")
 
-			if err != nil {
-				sugarLogger.Error("Error creating gzip writer")
-				http.Error(res, "Error creating gzip writer", http.StatusInternalServerError)
-				return
+		// Check Accept-Encoding, HEAD has no body to compress.
+		// The encoder is created by the first header or body the handler sends.
+		var coding string
+		if !config.NoCompression {
+			// The answer depends on Accept-Encoding, the caches must know it
+			res.Header().Add("Vary", "Accept-Encoding")
+			if req.Method != http.MethodHead {
+				coding = chooseEncoding(req.Header.Get("Accept-Encoding"))
 			}
-			defer wgzip.Close() // Send all the data!
 		}
 
+		// Limit the body as sent, then the decompressed one too (a small gzip body can unpack huge)
+		req.Body = limitBody(res, req.Body, bodyLimit(req))
+
 		// !Check Content-Encoding
 		if strings.Contains(req.Header.Get("Content-Encoding"), "gzip") {
+			var err error
 			rgzip, err = newDecompress(req.Body)
+			if bodyTooLarge(err) {
+				writeBodyError(res, err, "")
+				return
+			}
 			if err != nil {
 				sugarLogger.Error("Error creating gzip reader")
-				http.Error(res, "Error creating gzip reader", http.StatusInternalServerError)
+				writeJSONError(res, http.StatusInternalServerError, "Error creating gzip reader")
 				return
 			}
-			req.Body = rgzip
+			req.Body = limitBody(res, rgzip, bodyLimit(req))
 			defer rgzip.Close()
 		}
 
 		// ResponseWriter implementation
-		logRW := &ResLogOrCompress{res, &LogData{code: 0, size: 0}, wgzip}
+		logRW := &ResLogOrCompress{res: res, data: &LogData{code: 0, size: 0}, coding: coding}
 		timeDuration := time.Now() // query duration
+		defer logRW.Close()        // Send all the data!
 
 		// Handlers
-		if req.Method == http.MethodGet && regexp.MustCompile(`^/[a-zA-Z0-9-]+$`).MatchString(req.URL.Path) {
+		if (req.Method == http.MethodGet || req.Method == http.MethodHead) && regexp.MustCompile(`^/[a-zA-Z0-9-]+$`).MatchString(req.URL.Path) {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodGet && regexp.MustCompile(`^/[a-zA-Z0-9-]+/icon$`).MatchString(req.URL.Path) {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodGet && regexp.MustCompile(`^/api/expand/[a-zA-Z0-9-]+$`).MatchString(req.URL.Path) {
 			next.ServeHTTP(logRW, req)
 		} else if req.Method == http.MethodPost && req.URL.Path == "/" {
 			next.ServeHTTP(logRW, req)
-		} else if req.Method == http.MethodPost && req.URL.Path == "/api/shorten" {
+		} else if (req.Method == http.MethodPost || req.Method == http.MethodGet) && req.URL.Path == "/api/shorten" {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodPost && req.URL.Path == "/api/shorten/weighted" {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodPost && req.URL.Path == "/api/shorten/batch" {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodPost && req.URL.Path == expandStreamPath {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodPost && req.URL.Path == "/api/resolve/batch" {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodGet && req.URL.Path == "/api/version" {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodGet && req.URL.Path == "/api/internal/stats" {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodPost && req.URL.Path == "/api/internal/gunzip" {
+			next.ServeHTTP(logRW, req)
+		} else if (req.Method == http.MethodPut || req.Method == http.MethodDelete) && req.URL.Path == "/api/internal/banner" {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodDelete && req.URL.Path == "/api/internal/urls" {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodGet && req.URL.Path == "/api/internal/export" {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodPost && req.URL.Path == "/api/internal/import" {
+			next.ServeHTTP(logRW, req)
+		} else if req.Method == http.MethodOptions { // the unknown paths are answered 404 by allowOptions
 			next.ServeHTTP(logRW, req)
 		} else {
-			http.Error(res, "Invalid URL", http.StatusBadRequest)
-			logRW.WriteHeader(http.StatusBadRequest)
-			logRW.Write([]byte("Invalid URL"))
+			writeJSONError(logRW, http.StatusBadRequest, "Invalid URL")
 		}
 
 		// Logging response
-		sugarLogger.Infow(
-			"Response parameters",
-			"Status Code", logRW.data.code,
-			"Size", logRW.data.size,
-			"Duration", time.Since(timeDuration),
-		)
+		if accessLog {
+			sugarLogger.Infow(
+				"Response parameters",
+				"Route", routePattern(req),
+				"Status Code", logRW.data.code,
+				"Size", logRW.data.size,
+				"Duration", time.Since(timeDuration),
+			)
+		}
 	})
 }
 
 func LaunchMyRouter(c *Connection) chi.Router {
+	limiters := newRateLimiters()
+
 	myRouter := chi.NewRouter()
-	myRouter.Use(checkURL)
-	myRouter.Get("/{id}", c.GetHandler)
-	myRouter.Post("/", c.PostHandler)
-	myRouter.Post("/api/shorten", c.PostHandlerJSON)
+	metrics := newMetrics(c.store)
+	myRouter.Use(cors, middleware.RequestID, checkURL, recoverPanic, allowOptions(myRouter), metrics.Middleware, requestTimeout)
+	myRouter.Get("/livez", c.LivezHandler) // matches the /{id} pattern of checkURL, no rate limit
+	myRouter.Get("/healthz", c.HealthzHandler)
+	myRouter.Get("/readyz", c.ReadyzHandler)
+	myRouter.Get("/api/version", c.VersionHandler)
+	// matches the /{id} pattern of checkURL too, only for the trusted subnet
+	myRouter.With(trustedSubnet(config.TrustedSubnet)).Method(http.MethodGet, "/metrics", metrics.Handler())
+	myRouter.With(limiters.Middleware(groupRedirect)).Get("/{id}", c.GetHandler)
+	myRouter.With(limiters.Middleware(groupRedirect)).Head("/{id}", c.GetHandler) // same headers, the body is dropped
+	myRouter.With(limiters.Middleware(groupRedirect)).Get("/api/expand/{id}", c.ExpandHandler)
+	myRouter.With(limiters.Middleware(groupRedirect)).Post(expandStreamPath, c.ExpandStreamHandler)
+	myRouter.With(limiters.Middleware(groupRedirect)).Post("/api/resolve/batch", c.ResolveBatchHandler)
+	myRouter.With(limiters.Middleware(groupShorten), c.idempotent).Post("/", c.PostHandler)
+	myRouter.With(limiters.Middleware(groupShorten), c.idempotent).Post("/api/shorten", c.PostHandlerJSON)
+	myRouter.With(limiters.Middleware(groupShorten)).Get("/api/shorten", c.GetHandlerShorten)
+	myRouter.With(limiters.Middleware(groupShorten), c.idempotent).Post("/api/shorten/weighted", c.PostHandlerWeighted)
+	myRouter.With(limiters.Middleware(groupShorten), c.idempotent).Post("/api/shorten/batch", c.PostHandlerBatch)
+	myRouter.Route("/api/internal", func(r chi.Router) { // only for the trusted subnet
+		r.Use(limiters.Middleware(groupAdmin), trustedSubnet(config.TrustedSubnet))
+		r.Get("/stats", c.StatsHandler)
+		r.Put("/banner", c.SetBannerHandler)
+		r.Delete("/banner", c.ClearBannerHandler)
+		r.Post("/gunzip", c.GunzipHandler)
+		r.Delete("/urls", c.DeleteURLsHandler)
+		r.Get("/export", c.ExportHandler)
+		r.Post("/import", c.ImportHandler)
+	})
+	if config.FaviconEnabled {
+		myRouter.With(limiters.Middleware(groupRedirect)).Get("/{id}/icon", c.IconHandler)
+	}
 
 	return myRouter
 }
 
 func main() {
 
-	c := &Connection{mapURLmain}
-
 	config.ParseFlags() // read a and b flags for host:port and {id} information
+	printBuildInfo(os.Stdout)
+	if config.ShowVersion {
+		return
+	}
+
+	var err error
+	var logSink io.Writer // stderr
+	if config.LogFile != "" {
+		logFile := newLogFile(config.LogFile, config.LogFileMaxSize, config.LogFileMaxBackups)
+		defer logFile.Close()
+		logSink = logFile
+	}
+	if logger, err = newLogger(config.LogMode, config.LogLevel, logSink); err != nil {
+		panic(err)
+	}
+	defer logger.Sync()
 
-	err := http.ListenAndServe(config.HostFlags.String(), LaunchMyRouter(c))
+	// SIGINT and SIGTERM shut the server down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	memStore := storage.NewMemStorage(mapURLmain)
+	if config.URLTTL > 0 { // the memory storage only, checked by the config
+		memStore.SetTTL(config.URLTTL)
+		go memStore.RunSweeper(ctx, config.URLSweepInterval, logger)
+	}
+	var store storage.Storage = memStore
+	if config.DatabaseDSN != "" {
+		dbStore, err := storage.NewPostgresStorage(ctx, config.DatabaseDSN, storage.PoolConfig{
+			MaxOpenConns:    config.DBMaxOpenConns,
+			MaxIdleConns:    config.DBMaxIdleConns,
+			ConnMaxLifetime: config.DBConnMaxLifetime,
+		})
+		if err != nil {
+			panic(err)
+		}
+		defer dbStore.Close()
+		store = dbStore
+	} else if config.FileStoragePath != "" {
+		fileStore, err := storage.NewFileStorage(config.FileStoragePath, config.FileStorageQuarantine, logger)
+		if err != nil {
+			panic(err)
+		}
+		defer fileStore.Close()
+		store = fileStore
+	}
+	if config.ArchiveMaxAge > 0 { // only the memory and file storages, checked by the config
+		var archive storage.Archive = storage.NewMemStorage(nil)
+		if config.ArchivePath != "" { // kept on the disk, not in memory
+			fileArchive, err := storage.NewColdStorage(config.ArchivePath, logger)
+			if err != nil {
+				panic(err)
+			}
+			defer fileArchive.Close()
+			archive = fileArchive
+		}
+		archived := storage.NewArchivedStorage(store.(storage.Archivable), archive)
+		go archived.Run(ctx, config.ArchiveInterval, config.ArchiveMaxAge, logger)
+		store = archived
+	}
+	if config.SeedFile != "" {
+		seeded, err := seedURLs(ctx, store, config.SeedFile)
+		if err != nil {
+			panic(err)
+		}
+		logger.Sugar().Infow("Seed links loaded", "File", config.SeedFile, "Saved", seeded)
+	}
+	c := NewConnection(store)
+	c.deleter = newDeleter(store, deleteQueueSize)
+	if config.IdempotencyTTL > 0 {
+		c.idempotency = storage.NewIdempotencyCache(config.IdempotencyTTL)
+	}
+	go c.deleter.Run()
+	c.ready.Store(true)
+	router := LaunchMyRouter(c)
+	if config.WatchdogInterval > 0 {
+		c.watchdog = newWatchdog(router, config.WatchdogTimeout)
+		go c.watchdog.Run(ctx, config.WatchdogInterval)
+	}
+
+	server := &http.Server{
+		Addr:         config.HostFlags.String(),
+		Handler:      router,
+		ReadTimeout:  config.ServerReadTimeout,
+		WriteTimeout: config.ServerWriteTimeout,
+		IdleTimeout:  config.ServerIdleTimeout,
+	}
+	listener, err := listen(config.HostFlags)
 	if err != nil {
 		panic(err)
 	}
+	serveErr := make(chan error, 2)
+	go func() { serveErr <- server.Serve(listener) }()
+	var grpcServer *grpc.Server
+	if config.GRPCAddress != "" {
+		listener, err := net.Listen("tcp", config.GRPCAddress)
+		if err != nil {
+			panic(err)
+		}
+		grpcServer = newGRPCServer(c)
+		go func() { serveErr <- grpcServer.Serve(listener) }()
+	}
+	select {
+	case err = <-serveErr:
+		panic(err)
+	case <-ctx.Done():
+	}
+	c.ready.Store(false) // no new traffic while draining
+
+	// the requests in flight finish first, then no one enqueues deletes anymore
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		logger.Sugar().Errorw("Server shutdown", "Error", err)
+	}
+	if grpcServer != nil {
+		stopGRPC(shutdownCtx, grpcServer)
+	}
+	if err = c.deleter.Shutdown(shutdownCtx); err != nil {
+		logger.Sugar().Errorw("Queued deletes dropped", "Error", err)
+	}
 }
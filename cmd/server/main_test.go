@@ -3,12 +3,24 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/models"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 type testRequestOptions struct {
@@ -61,12 +73,23 @@ func Test_GetHandler(t *testing.T) {
 			},
 			Path:     "/test",
 			Method:   http.MethodGet,
-			WantCode: 400,
+			WantCode: http.StatusNotFound,
+		},
+		{
+			Name: "Known id among several",
+			MapURL: map[string]string{
+				"sharaga": "https://mai.ru",
+				"api":     "https://practicum.net/api?x=1",
+			},
+			Path:         "/api",
+			Method:       http.MethodGet,
+			WantCode:     http.StatusTemporaryRedirect,
+			WantLocation: "https://practicum.net/api?x=1",
 		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.Name, func(t *testing.T) {
-			connection := &Connection{tc.MapURL}
+			connection := NewConnection(storage.NewMemStorage(tc.MapURL))
 			ts := httptest.NewServer(LaunchMyRouter(connection))
 			resp := testRequest(testRequestOptions{
 				t:      t,
@@ -81,6 +104,79 @@ func Test_GetHandler(t *testing.T) {
 	}
 }
 
+// The unknown ids are sent to the landing page when it's configured, else 404
+func Test_GetHandlerNotFoundRedirect(t *testing.T) {
+	defer func(landing string) { config.NotFoundRedirectURL = landing }(config.NotFoundRedirectURL)
+
+	tests := []struct {
+		Name         string
+		Landing      string
+		Path         string
+		WantCode     int
+		WantLocation string
+	}{
+		{
+			Name:         "Landing page",
+			Landing:      "https://practicum.net/landing",
+			Path:         "/test",
+			WantCode:     http.StatusFound,
+			WantLocation: "https://practicum.net/landing",
+		},
+		{
+			Name:         "Known id with landing page",
+			Landing:      "https://practicum.net/landing",
+			Path:         "/sharaga",
+			WantCode:     http.StatusTemporaryRedirect,
+			WantLocation: "https://mai.ru",
+		},
+		{
+			Name:     "No landing page",
+			Path:     "/test",
+			WantCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			config.NotFoundRedirectURL = tc.Landing
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+			defer ts.Close()
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: tc.Path})
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			require.Equal(t, tc.WantLocation, resp.Header.Get("Location"))
+		})
+	}
+}
+
+// The redirect goes out with the configured status
+func Test_GetHandlerRedirectStatus(t *testing.T) {
+	defer func(status int) { config.RedirectStatus = status }(config.RedirectStatus)
+
+	for _, status := range []int{
+		http.StatusMovedPermanently,
+		http.StatusFound,
+		http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect,
+	} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			config.RedirectStatus = status
+			connection := NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))
+			ts := httptest.NewServer(LaunchMyRouter(connection))
+			defer ts.Close()
+			resp := testRequest(testRequestOptions{
+				t:      t,
+				ts:     ts,
+				method: http.MethodGet,
+				path:   "/sharaga",
+				body:   nil,
+			})
+			require.Equal(t, status, resp.StatusCode)
+			require.Equal(t, "https://mai.ru", resp.Header.Get("Location"))
+		})
+	}
+}
+
 // Test compression - no need, the body is always empty
 /*
 func Test_GzipGetHandler(t *testing.T) {
@@ -105,7 +201,7 @@ func Test_GzipGetHandler(t *testing.T) {
 	}
 	for _, tc := range tests { // Accept compression
 		t.Run(tc.Name, func(t *testing.T) {
-			connection := &Connection{tc.MapURL}
+			connection := NewConnection(storage.NewMemStorage(tc.MapURL))
 			ts := httptest.NewServer(LaunchMyRouter(connection))
 
 			req, err := http.NewRequest(
@@ -170,7 +266,7 @@ func Test_PostHandler(t *testing.T) {
 		t.Run(tc.Name, func(t *testing.T) {
 			newBuffer := bytes.NewBuffer([]byte(tc.Body))
 			require.NotEmpty(t, newBuffer) // original URL mustn't be empty
-			testConnect := &Connection{tc.MapURL}
+			testConnect := NewConnection(storage.NewMemStorage(tc.MapURL))
 			ts := httptest.NewServer(LaunchMyRouter(testConnect))
 			resp := testRequest(testRequestOptions{
 				t:      t,
@@ -187,6 +283,8 @@ func Test_PostHandler(t *testing.T) {
 // TESTING THE COMPRESSION
 
 func Test_GzipPostHandler(t *testing.T) {
+	setCompressMinSize(t, 0) // the short answers are compressed too
+
 	tests := []struct {
 		Name     string
 		MapURL   map[string]string
@@ -213,14 +311,14 @@ func Test_GzipPostHandler(t *testing.T) {
 			var bodyResp []byte
 			newBuffer := bytes.NewBuffer([]byte(tc.Body))
 			require.NotEmpty(t, newBuffer) // original URL mustn't be empty
-			testConnect := &Connection{tc.MapURL}
+			testConnect := NewConnection(storage.NewMemStorage(tc.MapURL))
 
 			// Set request params
 			ts := httptest.NewServer(LaunchMyRouter(testConnect))
 			req, err := http.NewRequest(
 				tc.Method,
 				ts.URL+tc.Path,
-				nil,
+				newBuffer,
 			)
 			req.Header.Set("Accept-Encoding", "gzip") // Accept compression
 			require.NoError(t, err)
@@ -262,7 +360,7 @@ func Test_GzipPostHandler(t *testing.T) {
 			require.NoError(t, err)
 
 			// set request params
-			testConnect := &Connection{tc.MapURL}
+			testConnect := NewConnection(storage.NewMemStorage(tc.MapURL))
 			ts := httptest.NewServer(LaunchMyRouter(testConnect))
 			req, err := http.NewRequest(
 				tc.Method,
@@ -282,6 +380,43 @@ func Test_GzipPostHandler(t *testing.T) {
 	}
 }
 
+// The root POST body is trimmed, the blank and the non-URL ones are rejected before the storage
+func Test_PostHandlerBlankBody(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Body         string
+		WantCode     int
+		WantOriginal string
+	}{
+		{Name: "Spaces", Body: "   ", WantCode: http.StatusBadRequest},
+		{Name: "Newlines", Body: "\n\r\n\t\n", WantCode: http.StatusBadRequest},
+		{Name: "Empty", Body: "", WantCode: http.StatusBadRequest},
+		{Name: "Not a URL", Body: "practicum", WantCode: http.StatusBadRequest},
+		{Name: "Surrounding whitespace", Body: "  https://practicum.net\n", WantCode: http.StatusCreated, WantOriginal: "https://practicum.net"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			store := storage.NewMemStorage(nil)
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+			defer ts.Close()
+
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/", body: bytes.NewBufferString(tc.Body)})
+			defer resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+
+			stats, err := store.Stats(context.Background())
+			require.NoError(t, err)
+			if tc.WantOriginal == "" {
+				require.Zero(t, stats.URLs, "nothing is stored")
+				return
+			}
+			original, ok := store.Get(context.Background(), resp.Header.Get(shortIDHeader))
+			require.True(t, ok)
+			require.Equal(t, tc.WantOriginal, original)
+		})
+	}
+}
+
 // Test the JSON handler
 func TestPostHandlerJSON(t *testing.T) {
 	testBlock := []struct {
@@ -291,6 +426,7 @@ func TestPostHandlerJSON(t *testing.T) {
 		Method   string
 		Body     string
 		WantCode int
+		WantBody string // checked when set
 	}{
 		{
 			Name:     "Positive test 1",
@@ -300,6 +436,14 @@ func TestPostHandlerJSON(t *testing.T) {
 			Body:     `{"url": "https://ilovebebra.com"}`,
 			WantCode: http.StatusCreated,
 		},
+		{
+			Name:     "Positive test 2", // trailing spaces
+			MapURL:   map[string]string{},
+			Path:     "/api/shorten",
+			Method:   http.MethodPost,
+			Body:     "{\"url\": \"https://ilovebebra.com\"}\n  ",
+			WantCode: http.StatusCreated,
+		},
 		{
 			Name:     "Negative test 1", // incorrect path
 			MapURL:   map[string]string{},
@@ -324,11 +468,65 @@ func TestPostHandlerJSON(t *testing.T) {
 			Body:     `<"url": "https://ilovebebra.com">`,
 			WantCode: http.StatusBadRequest,
 		},
+		{
+			Name:     "Negative test 4", // empty url
+			MapURL:   map[string]string{},
+			Path:     "/api/shorten",
+			Method:   http.MethodPost,
+			Body:     `{"url": ""}`,
+			WantCode: http.StatusBadRequest,
+			WantBody: `{"error": "The url field must not be empty"}`,
+		},
+		{
+			Name:     "Negative test 5", // no url
+			MapURL:   map[string]string{},
+			Path:     "/api/shorten",
+			Method:   http.MethodPost,
+			Body:     `{}`,
+			WantCode: http.StatusBadRequest,
+			WantBody: `{"error": "The url field must not be empty"}`,
+		},
+		{
+			Name:     "Negative test 6", // unknown field
+			MapURL:   map[string]string{},
+			Path:     "/api/shorten",
+			Method:   http.MethodPost,
+			Body:     `{"url": "https://ilovebebra.com", "alias": "bebra"}`,
+			WantCode: http.StatusBadRequest,
+			WantBody: `{"error": "Invalid JSON"}`,
+		},
+		{
+			Name:     "Negative test 7", // trailing junk
+			MapURL:   map[string]string{},
+			Path:     "/api/shorten",
+			Method:   http.MethodPost,
+			Body:     `{"url": "https://ilovebebra.com"} junk`,
+			WantCode: http.StatusBadRequest,
+			WantBody: `{"error": "Invalid JSON"}`,
+		},
+		{
+			Name:     "Negative test 8", // blank url
+			MapURL:   map[string]string{},
+			Path:     "/api/shorten",
+			Method:   http.MethodPost,
+			Body:     `{"url": "  "}`,
+			WantCode: http.StatusBadRequest,
+			WantBody: `{"error": "The url field must not be empty"}`,
+		},
+		{
+			Name:     "Negative test 9", // not an http(s) url
+			MapURL:   map[string]string{},
+			Path:     "/api/shorten",
+			Method:   http.MethodPost,
+			Body:     `{"url": "javascript:alert(1)"}`,
+			WantCode: http.StatusBadRequest,
+			WantBody: `{"error": "The url field must be an absolute http(s) URL"}`,
+		},
 	}
 
 	for _, tc := range testBlock {
 		t.Run(tc.Name, func(t *testing.T) {
-			newConnect := &Connection{mapURL: tc.MapURL} // connect having optional map
+			newConnect := NewConnection(storage.NewMemStorage(tc.MapURL)) // connect having optional map
 			newBody := bytes.NewBuffer([]byte(tc.Body))
 			require.NotEmpty(t, newBody) // body must json, not empty
 
@@ -342,13 +540,21 @@ func TestPostHandlerJSON(t *testing.T) {
 					body:   newBody,
 				},
 			)
+			defer resp.Body.Close()
 			require.Equal(t, tc.WantCode, resp.StatusCode)
+			if tc.WantBody != "" {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.JSONEq(t, tc.WantBody, string(body))
+			}
 		})
 	}
 }
 
 // CHECK THE COMPRESSION
 func Test_GzipPostHandlerJSON(t *testing.T) {
+	setCompressMinSize(t, 0) // the short answers are compressed too
+
 	testBlock := []struct {
 		Name     string
 		MapURL   map[string]string // you can't use handler without content struct type so the map is needed :(
@@ -374,7 +580,7 @@ func Test_GzipPostHandlerJSON(t *testing.T) {
 
 			newBuffer := bytes.NewBuffer([]byte(tc.Body))
 			require.NotEmpty(t, newBuffer) // original URL mustn't be empty
-			testConnect := &Connection{tc.MapURL}
+			testConnect := NewConnection(storage.NewMemStorage(tc.MapURL))
 
 			// set request parameters
 			ts := httptest.NewServer(LaunchMyRouter(testConnect))
@@ -423,7 +629,7 @@ func Test_GzipPostHandlerJSON(t *testing.T) {
 			require.NoError(t, err)
 
 			// Set a request
-			testConnect := &Connection{tc.MapURL}
+			testConnect := NewConnection(storage.NewMemStorage(tc.MapURL))
 			ts := httptest.NewServer(LaunchMyRouter(testConnect))
 			req, err := http.NewRequest(
 				tc.Method,
@@ -442,3 +648,702 @@ func Test_GzipPostHandlerJSON(t *testing.T) {
 		})
 	}
 }
+
+// Colliding ids must be regenerated instead of overwriting the stored ones
+func Test_PostHandlerCollision(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Path     string
+		Body     string
+		IDs      []string // ids returned by the generator one by one
+		WantCode int
+		WantID   string
+	}{
+		{
+			Name:     "Retry plain",
+			Path:     "/",
+			Body:     "https://practicum.net",
+			IDs:      []string{"sharaga", "sharaga", "fresh"},
+			WantCode: http.StatusCreated,
+			WantID:   "fresh",
+		},
+		{
+			Name:     "Retry JSON",
+			Path:     "/api/shorten",
+			Body:     `{"url": "https://practicum.net"}`,
+			IDs:      []string{"sharaga", "fresh"},
+			WantCode: http.StatusCreated,
+			WantID:   "fresh",
+		},
+		{
+			Name:     "Attempts exhausted",
+			Path:     "/",
+			Body:     "https://practicum.net",
+			IDs:      []string{"sharaga"},
+			WantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			// mock the generator, the last id repeats forever
+			calls := 0
+			oldShortID := newShortID
+			newShortID = func() string {
+				id := tc.IDs[min(calls, len(tc.IDs)-1)]
+				calls++
+				return id
+			}
+			defer func() { newShortID = oldShortID }()
+
+			store := storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+			defer ts.Close()
+			resp := testRequest(testRequestOptions{
+				t:      t,
+				ts:     ts,
+				method: http.MethodPost,
+				path:   tc.Path,
+				body:   bytes.NewBufferString(tc.Body),
+			})
+			defer resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+
+			// the pre-populated id is untouched
+			original, _ := store.Get(context.Background(), "sharaga")
+			require.Equal(t, "https://mai.ru", original)
+			if tc.WantID != "" {
+				require.Equal(t, len(tc.IDs), calls)
+				original, ok := store.Get(context.Background(), tc.WantID)
+				require.True(t, ok)
+				require.Equal(t, "https://practicum.net", original)
+			} else {
+				require.Equal(t, maxIDAttempts, calls)
+			}
+		})
+	}
+}
+
+// The generated ids follow the configured length and alphabet
+func Test_RandStringConfig(t *testing.T) {
+	oldLength, oldAlphabet := config.ShortIDLength, config.ShortIDAlphabet
+	config.ShortIDLength, config.ShortIDAlphabet = 4, "ab"
+	defer func() { config.ShortIDLength, config.ShortIDAlphabet = oldLength, oldAlphabet }()
+
+	store := storage.NewMemStorage(nil)
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+	defer ts.Close()
+	resp := testRequest(testRequestOptions{
+		t:      t,
+		ts:     ts,
+		method: http.MethodPost,
+		path:   "/",
+		body:   bytes.NewBufferString("https://practicum.net"),
+	})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Regexp(t, `^/[ab]{4}$`, string(body))
+}
+
+// The logging wrapper records the code written by the handlers
+func Test_ResLogOrCompressCode(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Write    func(res http.ResponseWriter)
+		WantCode int
+	}{
+		{
+			Name: "Explicit code",
+			Write: func(res http.ResponseWriter) {
+				res.WriteHeader(http.StatusBadRequest)
+				res.Write([]byte("Invalid URL for GET"))
+			},
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "Implicit 200",
+			Write:    func(res http.ResponseWriter) { res.Write([]byte("ok")) },
+			WantCode: http.StatusOK,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			logRW := &ResLogOrCompress{res: rec, data: &LogData{}}
+			tc.Write(logRW)
+			require.Equal(t, tc.WantCode, logRW.data.code)
+			require.Equal(t, tc.WantCode, rec.Code)
+		})
+	}
+}
+
+// The plain POST response keeps its configured Content-Type, the short answer isn't gzipped
+func Test_PostHandlerContentType(t *testing.T) {
+	oldContentType := config.PlainContentType
+	defer func() { config.PlainContentType = oldContentType }()
+
+	tests := []struct {
+		Name            string
+		ContentType     string
+		AcceptEncoding  string
+		WantContentType string
+	}{
+		{
+			Name:            "Default",
+			ContentType:     oldContentType,
+			WantContentType: "text/plain; charset=utf-8",
+		},
+		{
+			Name:            "Default gzipped",
+			ContentType:     oldContentType,
+			AcceptEncoding:  "gzip",
+			WantContentType: "text/plain; charset=utf-8",
+		},
+		{
+			Name:            "Custom",
+			ContentType:     "text/uri-list",
+			WantContentType: "text/uri-list",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			config.PlainContentType = tc.ContentType
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/", bytes.NewBufferString("https://practicum.net"))
+			require.NoError(t, err)
+			if tc.AcceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.AcceptEncoding)
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			require.Equal(t, tc.WantContentType, resp.Header.Get("Content-Type"))
+			require.Empty(t, resp.Header.Get("Content-Encoding"))
+		})
+	}
+}
+
+// The error answers are {"error": "message"} JSON with the same status codes
+func Test_JSONErrors(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Path      string
+		Method    string
+		Body      string
+		WantCode  int
+		WantError string
+	}{
+		{
+			Name:      "Unknown id",
+			Path:      "/test",
+			Method:    http.MethodGet,
+			WantCode:  http.StatusNotFound,
+			WantError: "Unknown short URL",
+		},
+		{
+			Name:      "Incorrect json",
+			Path:      "/api/shorten",
+			Method:    http.MethodPost,
+			Body:      `<"url": "https://ilovebebra.com">`,
+			WantCode:  http.StatusBadRequest,
+			WantError: "Invalid JSON",
+		},
+		{
+			Name:      "Incorrect path",
+			Path:      "/api/path",
+			Method:    http.MethodPost,
+			Body:      `{"url": "https://ilovebebra.com"}`,
+			WantCode:  http.StatusBadRequest,
+			WantError: "Invalid URL",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+			defer ts.Close()
+			resp := testRequest(testRequestOptions{
+				t:      t,
+				ts:     ts,
+				method: tc.Method,
+				path:   tc.Path,
+				body:   bytes.NewBufferString(tc.Body),
+			})
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			var errResp models.ErrorResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+			require.Equal(t, tc.WantError, errResp.Error)
+		})
+	}
+}
+
+// HEAD resolves the redirect without a body
+func Test_HeadHandler(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Path         string
+		WantCode     int
+		WantLocation string
+	}{
+		{
+			Name:         "Known id",
+			Path:         "/sharaga",
+			WantCode:     http.StatusTemporaryRedirect,
+			WantLocation: "https://mai.ru",
+		},
+		{
+			Name:     "Unknown id",
+			Path:     "/test",
+			WantCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+			defer ts.Close()
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodHead, path: tc.Path})
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			require.Equal(t, tc.WantLocation, resp.Header.Get("Location"))
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Empty(t, body)
+		})
+	}
+}
+
+func Test_PostHandlerRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	// shortenFile posts the url to the server over the file storage, closed after the request
+	shortenFile := func(t *testing.T, body string) (int, string) {
+		store, err := storage.NewFileStorage(path, false, zap.NewNop())
+		require.NoError(t, err)
+		defer store.Close()
+		ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+		defer ts.Close()
+
+		resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/", body: bytes.NewBufferString(body)})
+		defer resp.Body.Close()
+		shortURL, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(shortURL)
+	}
+
+	code, first := shortenFile(t, "https://practicum.net")
+	require.Equal(t, http.StatusCreated, code)
+
+	// the restarted store still knows the original url
+	code, again := shortenFile(t, "https://practicum.net")
+	require.Equal(t, http.StatusConflict, code)
+	require.Equal(t, first, again)
+
+	code, other := shortenFile(t, "https://mai.ru")
+	require.Equal(t, http.StatusCreated, code)
+	require.NotEqual(t, first, other)
+}
+
+func Test_ShortIDHeader(t *testing.T) {
+	oldShortID := newShortID
+	newShortID = func() string { return "fresh" }
+	defer func() { newShortID = oldShortID }()
+
+	tests := []struct {
+		Name     string
+		Path     string
+		Body     string
+		WantCode int
+		WantID   string
+	}{
+		{
+			Name:     "Plain",
+			Path:     "/",
+			Body:     "https://practicum.net",
+			WantCode: http.StatusCreated,
+			WantID:   "fresh",
+		},
+		{
+			Name:     "JSON",
+			Path:     "/api/shorten",
+			Body:     `{"url": "https://practicum.net"}`,
+			WantCode: http.StatusCreated,
+			WantID:   "fresh",
+		},
+		{
+			Name:     "Already shortened",
+			Path:     "/api/shorten",
+			Body:     `{"url": "https://mai.ru"}`,
+			WantCode: http.StatusConflict,
+			WantID:   "sharaga",
+		},
+		{
+			Name:     "Invalid JSON",
+			Path:     "/api/shorten",
+			Body:     `{"url": `,
+			WantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+			defer ts.Close()
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: tc.Path, body: bytes.NewBufferString(tc.Body)})
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			require.Equal(t, tc.WantID, resp.Header.Get("X-Short-Id"))
+		})
+	}
+}
+
+func Test_PostHandlerConcurrent(t *testing.T) {
+	store := storage.NewMemStorage(nil)
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+	defer ts.Close()
+
+	const clients = 20
+	var wg sync.WaitGroup
+	codes := make(chan int, clients)
+	ids := make(chan string, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := ts.Client().Post(ts.URL+"/", "text/plain", bytes.NewBufferString("https://practicum.net"))
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			codes <- resp.StatusCode
+			ids <- resp.Header.Get("X-Short-Id")
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	close(ids)
+
+	// one request creates the url, the others get it back
+	created := 0
+	for code := range codes {
+		if code == http.StatusCreated {
+			created++
+		} else {
+			require.Equal(t, http.StatusConflict, code)
+		}
+	}
+	require.Equal(t, 1, created)
+	first := <-ids
+	for id := range ids {
+		require.Equal(t, first, id)
+	}
+	stats, err := store.Stats(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.URLs)
+}
+
+func Test_GetHandlerDeleted(t *testing.T) {
+	store := storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+	require.NoError(t, store.Delete(context.Background(), []string{"sharaga"}))
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+	defer ts.Close()
+
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/sharaga"})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusGone, resp.StatusCode)
+	require.Empty(t, resp.Header.Get("Location"))
+
+	// the deleted original can be shortened again
+	resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/", body: bytes.NewBufferString("https://mai.ru")})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NotEqual(t, "sharaga", resp.Header.Get("X-Short-Id"))
+}
+
+func Test_GetHandlerArchived(t *testing.T) {
+	store := storage.NewArchivedStorage(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}), storage.NewMemStorage(nil))
+	archived, err := store.Archive(context.Background(), 0) // everything is old
+	require.NoError(t, err)
+	require.Equal(t, 1, archived)
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+	defer ts.Close()
+
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/sharaga"})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	require.Equal(t, "https://mai.ru", resp.Header.Get("Location"))
+}
+
+// The plain POST accepts text/plain or no type, JSON belongs to /api/shorten
+func Test_PostHandlerRequestContentType(t *testing.T) {
+	tests := []struct {
+		Name        string
+		ContentType string
+		Body        string
+		WantCode    int
+	}{
+		{Name: "Plain text", ContentType: "text/plain", Body: "https://practicum.net", WantCode: http.StatusCreated},
+		{Name: "Plain text with charset", ContentType: "text/plain; charset=utf-8", Body: "https://practicum.net", WantCode: http.StatusCreated},
+		{Name: "No type", Body: "https://practicum.net", WantCode: http.StatusCreated},
+		{Name: "JSON", ContentType: "application/json", Body: `{"url": "https://practicum.net"}`, WantCode: http.StatusUnsupportedMediaType},
+		{Name: "Form", ContentType: "application/x-www-form-urlencoded", Body: "url=https://practicum.net", WantCode: http.StatusUnsupportedMediaType},
+		{Name: "Malformed", ContentType: "text/plain; charset", Body: "https://practicum.net", WantCode: http.StatusUnsupportedMediaType},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			store := storage.NewMemStorage(nil)
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/", bytes.NewBufferString(tc.Body))
+			require.NoError(t, err)
+			if tc.ContentType != "" {
+				req.Header.Set("Content-Type", tc.ContentType)
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			stats, err := store.Stats(context.Background())
+			require.NoError(t, err)
+			if tc.WantCode != http.StatusCreated {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.JSONEq(t, `{"error": "Content-Type must be text/plain, send JSON to /api/shorten"}`, string(body))
+				require.Zero(t, stats.URLs, "saved anyway")
+			} else {
+				require.Equal(t, 1, stats.URLs)
+			}
+		})
+	}
+}
+
+// The bodies over the limit are refused with 413, the gzipped ones after decompression too
+func Test_MaxBodySize(t *testing.T) {
+	oldMaxBodySize := config.MaxBodySize
+	config.MaxBodySize = 100
+	defer func() { config.MaxBodySize = oldMaxBodySize }()
+
+	gzipBody := func(body string) []byte {
+		gzipped := bytes.NewBuffer(nil)
+		writer := gzip.NewWriter(gzipped)
+		_, err := writer.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		return gzipped.Bytes()
+	}
+	longURL := "https://practicum.net/" + strings.Repeat("a", 10000)
+	require.Less(t, len(gzipBody(longURL)), 100) // unpacks over the limit
+
+	tests := []struct {
+		Name     string
+		Path     string
+		Body     []byte
+		Gzip     bool
+		WantCode int
+	}{
+		{Name: "Plain under the limit", Path: "/", Body: []byte("https://practicum.net"), WantCode: http.StatusCreated},
+		{Name: "Plain over the limit", Path: "/", Body: []byte(longURL), WantCode: http.StatusRequestEntityTooLarge},
+		{Name: "JSON under the limit", Path: "/api/shorten", Body: []byte(`{"url": "https://practicum.net"}`), WantCode: http.StatusCreated},
+		{Name: "JSON over the limit", Path: "/api/shorten", Body: []byte(`{"url": "` + longURL + `"}`), WantCode: http.StatusRequestEntityTooLarge},
+		{Name: "Gzip under the limit", Path: "/", Body: gzipBody("https://practicum.net"), Gzip: true, WantCode: http.StatusCreated},
+		{Name: "Gzip unpacked over the limit", Path: "/", Body: gzipBody(longURL), Gzip: true, WantCode: http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			store := storage.NewMemStorage(nil)
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+tc.Path, bytes.NewReader(tc.Body))
+			require.NoError(t, err)
+			if tc.Gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			stats, err := store.Stats(context.Background())
+			require.NoError(t, err)
+			if tc.WantCode == http.StatusRequestEntityTooLarge {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.JSONEq(t, `{"error": "Request body is too large"}`, string(body))
+				require.Zero(t, stats.URLs)
+			} else {
+				require.Equal(t, 1, stats.URLs)
+			}
+		})
+	}
+}
+
+func Test_DecodeJSON(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Body    string
+		WantErr bool
+	}{
+		{Name: "Valid", Body: `{"url": "https://mai.ru"}`},
+		{Name: "Trailing newline", Body: "{\"url\": \"https://mai.ru\"}\n"},
+		{Name: "Unknown field", Body: `{"url": "https://mai.ru", "extra": 1}`, WantErr: true},
+		{Name: "Second value", Body: `{"url": "https://mai.ru"}{"url": "https://practicum.net"}`, WantErr: true},
+		{Name: "Trailing junk", Body: `{"url": "https://mai.ru"}]`, WantErr: true},
+		{Name: "Empty", Body: ``, WantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			var someURL models.SomeURL
+			err := decodeJSON(strings.NewReader(tc.Body), &someURL)
+			if tc.WantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "https://mai.ru", someURL.URL)
+		})
+	}
+}
+
+var errStorageDown = errors.New("connection refused")
+
+// failingStore is the memory storage with the database down
+type failingStore struct {
+	*storage.MemStorage
+}
+
+func (failingStore) Lookup(ctx context.Context, shortURL string) (string, error) {
+	return "", errStorageDown
+}
+
+func (failingStore) GetOrCreate(ctx context.Context, shortURL, originalURL string) (string, bool, error) {
+	return "", false, errStorageDown
+}
+
+// The storage failures are answered 500 and logged, unlike the unknown ids
+func Test_StorageErrors(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Store    storage.Storage
+		Method   string
+		Path     string
+		Body     string
+		WantCode int
+		WantBody string
+		WantLog  string
+	}{
+		{
+			Name:     "Redirect",
+			Store:    failingStore{storage.NewMemStorage(nil)},
+			Method:   http.MethodGet,
+			Path:     "/sharaga",
+			WantCode: http.StatusInternalServerError,
+			WantBody: `{"error":"Storage error"}`,
+			WantLog:  "Storage error",
+		},
+		{
+			Name:     "Redirect of an unknown id",
+			Store:    storage.NewMemStorage(nil),
+			Method:   http.MethodGet,
+			Path:     "/sharaga",
+			WantCode: http.StatusNotFound,
+			WantBody: `{"error":"Unknown short URL"}`,
+		},
+		{
+			Name:     "Expand",
+			Store:    failingStore{storage.NewMemStorage(nil)},
+			Method:   http.MethodGet,
+			Path:     "/api/expand/sharaga",
+			WantCode: http.StatusInternalServerError,
+			WantBody: `{"error":"Storage error"}`,
+			WantLog:  "Storage error",
+		},
+		{
+			Name:     "Expand an unknown id",
+			Store:    storage.NewMemStorage(nil),
+			Method:   http.MethodGet,
+			Path:     "/api/expand/sharaga",
+			WantCode: http.StatusNotFound,
+			WantBody: `{"error":"Unknown short URL"}`,
+		},
+		{
+			Name:     "Shorten",
+			Store:    failingStore{storage.NewMemStorage(nil)},
+			Method:   http.MethodPost,
+			Path:     "/api/shorten",
+			Body:     `{"url": "https://mai.ru"}`,
+			WantCode: http.StatusInternalServerError,
+			WantBody: `{"error":"Can't save short URL"}`,
+			WantLog:  "Can't save short URL",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			logs := observeLogs(t)
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(tc.Store)))
+			defer ts.Close()
+
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: tc.Method, path: tc.Path, body: bytes.NewBufferString(tc.Body)})
+			defer resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			var body bytes.Buffer
+			_, err := body.ReadFrom(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, tc.WantBody, body.String())
+
+			failures := logs.FilterLevelExact(zapcore.ErrorLevel).All()
+			if tc.WantLog == "" {
+				require.Empty(t, failures)
+				return
+			}
+			require.Len(t, failures, 1)
+			require.Equal(t, tc.WantLog, failures[0].Message)
+			require.Equal(t, errStorageDown.Error(), failures[0].ContextMap()["Error"])
+		})
+	}
+}
+
+// Every JSON endpoint names its body application/json, the new and the existing urls both
+func Test_JSONContentType(t *testing.T) {
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+	defer ts.Close()
+
+	tests := []struct {
+		Name     string
+		Method   string
+		Path     string
+		Body     string
+		WantCode int
+	}{
+		{Name: "Shorten", Method: http.MethodPost, Path: "/api/shorten", Body: `{"url": "https://practicum.net"}`, WantCode: http.StatusCreated},
+		{Name: "Shorten existing", Method: http.MethodPost, Path: "/api/shorten", Body: `{"url": "https://practicum.net"}`, WantCode: http.StatusConflict},
+		{Name: "Shorten query", Method: http.MethodGet, Path: "/api/shorten?url=https://ilovespb.ru", WantCode: http.StatusCreated},
+		{Name: "Weighted", Method: http.MethodPost, Path: "/api/shorten/weighted", Body: `{"targets": [{"url": "https://a.example", "weight": 1}]}`, WantCode: http.StatusCreated},
+		{Name: "Batch", Method: http.MethodPost, Path: "/api/shorten/batch", Body: `[{"correlation_id": "1", "original_url": "https://mai.ru/news"}]`, WantCode: http.StatusCreated},
+		{Name: "Expand", Method: http.MethodGet, Path: "/api/expand/sharaga", WantCode: http.StatusOK},
+		{Name: "Resolve", Method: http.MethodPost, Path: "/api/resolve/batch", Body: `["sharaga"]`, WantCode: http.StatusOK},
+		{Name: "Error", Method: http.MethodPost, Path: "/api/shorten", Body: `{"url": ""}`, WantCode: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: tc.Method, path: tc.Path, body: bytes.NewBufferString(tc.Body)})
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.True(t, json.Valid(body), "body %q", body)
+		})
+	}
+}
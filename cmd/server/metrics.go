@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics keeps the collectors of a router in its own registry
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newMetrics(store storage.Storage) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shortener_http_requests_total",
+			Help: "Handled requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "shortener_http_request_duration_seconds",
+			Help:    "Request handling duration by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+	}
+	storedURLs := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "shortener_stored_urls",
+		Help: "Number of the stored short urls.",
+	}, func() float64 {
+		stats, err := store.Stats(context.Background())
+		if err != nil {
+			return 0
+		}
+		return float64(stats.URLs)
+	})
+	m.registry.MustRegister(m.requests, m.duration, storedURLs)
+	return m
+}
+
+// Middleware counts and times the requests by the chi route pattern
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if isWatchdogProbe(req) { // not client traffic
+			next.ServeHTTP(res, req)
+			return
+		}
+		ww := middleware.NewWrapResponseWriter(res, req.ProtoMajor)
+		start := time.Now()
+		next.ServeHTTP(ww, req)
+
+		route := routePattern(req) // known after routing
+		if route == "" {
+			route = "unmatched"
+		}
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+		m.requests.WithLabelValues(route, req.Method, strconv.Itoa(code)).Inc()
+		m.duration.WithLabelValues(route, req.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Handler exposes the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{DisableCompression: true}) // checkURL compresses
+}
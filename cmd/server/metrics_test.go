@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Metrics(t *testing.T) {
+	oldSubnet := config.TrustedSubnet
+	defer func() { config.TrustedSubnet = oldSubnet }()
+
+	tests := []struct {
+		Name     string
+		Subnet   string
+		WantCode int
+	}{
+		{
+			Name:     "Trusted",
+			Subnet:   "127.0.0.0/8",
+			WantCode: http.StatusOK,
+		},
+		{
+			Name:     "Outside the trusted subnet",
+			Subnet:   "10.0.0.0/8",
+			WantCode: http.StatusForbidden,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			config.TrustedSubnet = tc.Subnet
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))))
+			defer ts.Close()
+
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/", body: bytes.NewBufferString("https://practicum.net")})
+			resp.Body.Close()
+			resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/sharaga"})
+			resp.Body.Close()
+
+			resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/metrics"})
+			defer resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			if tc.WantCode != http.StatusOK {
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), `shortener_http_requests_total{code="201",method="POST",route="/"} 1`)
+			require.Contains(t, string(body), `shortener_http_requests_total{code="307",method="GET",route="/{id}"} 1`)
+			require.Contains(t, string(body), `shortener_http_request_duration_seconds_count{method="GET",route="/{id}"} 1`)
+			require.Contains(t, string(body), "shortener_stored_urls 2")
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/absurd678/skill/cmd/config"
+)
+
+// defaultPorts are dropped from the host
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// normalizeURL spells the equivalent absolute urls the same way before they
+// are stored or looked up:
+//   - the scheme and the host are lowercased
+//   - the default port of the scheme (http 80, https 443) is dropped
+//   - the "/" path is dropped, https://example.com/ is https://example.com
+//   - with config.NormalizeSortQuery the query parameters are sorted by key
+//
+// The path, the fragment and an unparsable or relative url are kept as is.
+func normalizeURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Opaque != "" {
+		return raw
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host, port := u.Hostname(), u.Port()
+	host = strings.ToLower(host)
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") { // IPv6
+		host = "[" + host + "]"
+	}
+	u.Host = host
+	if u.Path == "/" {
+		u.Path, u.RawPath = "", ""
+	}
+	if config.NormalizeSortQuery && u.RawQuery != "" {
+		u.RawQuery = u.Query().Encode() // sorted by key
+	}
+	return u.String()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NormalizeURL(t *testing.T) {
+	tests := []struct {
+		Name      string
+		URL       string
+		SortQuery bool
+		Want      string
+	}{
+		{Name: "Already normal", URL: "https://example.com/path?q=1", Want: "https://example.com/path?q=1"},
+		{Name: "Trailing slash", URL: "https://example.com/", Want: "https://example.com"},
+		{Name: "Uppercase scheme and host", URL: "HTTPS://Example.COM/Path", Want: "https://example.com/Path"},
+		{Name: "Default https port", URL: "https://example.com:443/", Want: "https://example.com"},
+		{Name: "Default http port", URL: "http://example.com:80/a", Want: "http://example.com/a"},
+		{Name: "Other port", URL: "https://example.com:8443/", Want: "https://example.com:8443"},
+		{Name: "IPv6 default port", URL: "http://[::1]:80/", Want: "http://[::1]"},
+		{Name: "Path slash kept", URL: "https://example.com/a/", Want: "https://example.com/a/"},
+		{Name: "Query unsorted", URL: "https://example.com/?b=2&a=1", Want: "https://example.com?b=2&a=1"},
+		{Name: "Query sorted", URL: "https://example.com/?b=2&a=1", SortQuery: true, Want: "https://example.com?a=1&b=2"},
+		{Name: "Not absolute", URL: "example.com/", Want: "example.com/"},
+		{Name: "Not a URL", URL: "://bad", Want: "://bad"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			oldSort := config.NormalizeSortQuery
+			config.NormalizeSortQuery = tc.SortQuery
+			defer func() { config.NormalizeSortQuery = oldSort }()
+
+			require.Equal(t, tc.Want, normalizeURL(tc.URL))
+		})
+	}
+}
+
+func Test_PostHandlerNormalized(t *testing.T) {
+	oldSort := config.NormalizeSortQuery
+	config.NormalizeSortQuery = true
+	defer func() { config.NormalizeSortQuery = oldSort }()
+
+	store := storage.NewMemStorage(nil)
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+	defer ts.Close()
+
+	spellings := []string{
+		"https://example.com?a=1&b=2",
+		"https://example.com/?a=1&b=2",
+		"HTTPS://EXAMPLE.com:443/?b=2&a=1",
+		"https://Example.com/?b=2&a=1",
+	}
+	var id string
+	for i, spelling := range spellings {
+		resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/", body: bytes.NewBufferString(spelling)})
+		resp.Body.Close()
+		if i == 0 {
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			id = resp.Header.Get("X-Short-Id")
+			continue
+		}
+		require.Equal(t, http.StatusConflict, resp.StatusCode, spelling)
+		require.Equal(t, id, resp.Header.Get("X-Short-Id"))
+	}
+
+	stats, err := store.Stats(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.URLs)
+	original, ok := store.Get(context.Background(), id)
+	require.True(t, ok)
+	require.Equal(t, "https://example.com?a=1&b=2", original)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// routeMethods are tried against the router to build the Allow header
+var routeMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodDelete,
+}
+
+// allowOptions answers OPTIONS with 204 and the methods the router serves at
+// the path in Allow, 404 for a path nothing is served at. The CORS preflights
+// are answered by cors before.
+func allowOptions(router chi.Routes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodOptions {
+				next.ServeHTTP(res, req)
+				return
+			}
+			var allowed []string
+			for _, method := range routeMethods {
+				if router.Match(chi.NewRouteContext(), method, req.URL.Path) {
+					allowed = append(allowed, method)
+				}
+			}
+			if len(allowed) == 0 {
+				writeJSONError(res, http.StatusNotFound, "Unknown route")
+				return
+			}
+			res.Header().Set("Allow", strings.Join(append(allowed, http.MethodOptions), ", "))
+			res.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AllowOptions(t *testing.T) {
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+	defer ts.Close()
+
+	tests := []struct {
+		Name      string
+		Path      string
+		WantCode  int
+		WantAllow string
+	}{
+		{
+			Name:      "Shorten",
+			Path:      "/api/shorten",
+			WantCode:  http.StatusNoContent,
+			WantAllow: "GET, POST, OPTIONS",
+		},
+		{
+			Name:      "Short url",
+			Path:      "/sharaga",
+			WantCode:  http.StatusNoContent,
+			WantAllow: "GET, HEAD, OPTIONS",
+		},
+		{
+			Name:      "Root",
+			Path:      "/",
+			WantCode:  http.StatusNoContent,
+			WantAllow: "POST, OPTIONS",
+		},
+		{
+			Name:      "Banner",
+			Path:      "/api/internal/banner",
+			WantCode:  http.StatusNoContent,
+			WantAllow: "PUT, DELETE, OPTIONS",
+		},
+		{
+			Name:     "Unknown route",
+			Path:     "/api/unknown/route",
+			WantCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodOptions, path: tc.Path})
+			resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			require.Equal(t, tc.WantAllow, resp.Header.Get("Allow"))
+		})
+	}
+}
+
+// The CORS preflight is still answered by cors, without Allow
+func Test_AllowOptionsPreflight(t *testing.T) {
+	oldOrigins := config.CORSAllowedOrigins
+	config.CORSAllowedOrigins = "https://app.example.com"
+	defer func() { config.CORSAllowedOrigins = oldOrigins }()
+
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodOptions, ts.URL+"/api/shorten", nil)
+	require.NoError(t, err)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.NotEmpty(t, resp.Header.Get("Access-Control-Allow-Methods"))
+	require.Empty(t, resp.Header.Get("Allow"))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/absurd678/skill/cmd/config"
+	"golang.org/x/time/rate"
+)
+
+// Endpoint groups with their own rate limits
+const (
+	groupRedirect = "redirect"
+	groupShorten  = "shorten"
+	groupAdmin    = "admin"
+)
+
+// limiterIdle is how long the bucket of a silent client is kept, its bucket is
+// refilled long before, so a new one is the same
+const limiterIdle = 10 * time.Minute
+
+// ----------------------RateLimiters----------------------------
+type RateLimiters struct {
+	mu        sync.Mutex
+	limits    map[string]float64        // group -> requests per second
+	limiters  map[string]*clientLimiter // group + client -> token bucket
+	lastSweep time.Time
+	now       func() time.Time // replaced by the tests
+}
+
+type clientLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiters() *RateLimiters {
+	return &RateLimiters{
+		limits: map[string]float64{
+			groupRedirect: config.RateLimitRedirect,
+			groupShorten:  config.RateLimitShorten,
+			groupAdmin:    config.RateLimitAdmin,
+		},
+		limiters:  map[string]*clientLimiter{},
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether the client may do one more request to the group
+func (rl *RateLimiters) Allow(group, client string) bool {
+	limit := rl.limits[group]
+	if limit <= 0 { // no limit for the group
+		return true
+	}
+
+	key := group + "|" + client
+	rl.mu.Lock()
+	now := rl.now()
+	rl.sweep(now)
+	limiter, ok := rl.limiters[key]
+	if !ok {
+		burst := int(limit)
+		if burst < 1 {
+			burst = 1
+		}
+		limiter = &clientLimiter{Limiter: rate.NewLimiter(rate.Limit(limit), burst)}
+		rl.limiters[key] = limiter
+	}
+	limiter.lastSeen = now
+	rl.mu.Unlock()
+
+	return limiter.Allow()
+}
+
+// sweep drops the buckets idle for limiterIdle, once in limiterIdle, rl.mu must be held
+func (rl *RateLimiters) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < limiterIdle {
+		return
+	}
+	rl.lastSweep = now
+	for key, limiter := range rl.limiters {
+		if now.Sub(limiter.lastSeen) >= limiterIdle {
+			delete(rl.limiters, key)
+		}
+	}
+}
+
+// Middleware limits the requests of the group per client address
+func (rl *RateLimiters) Middleware(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			client := req.RemoteAddr // not an ip, still one bucket per connection address
+			if ip := clientIP(req); ip != nil {
+				client = ip.String()
+			}
+			if !rl.Allow(group, client) {
+				writeJSONError(res, http.StatusTooManyRequests, "Too many requests")
+				return
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+// ----------------------RateLimiters----------------------------
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// setRateLimits sets the config limits for the test and restores them after
+func setRateLimits(t *testing.T, redirect, shorten, admin float64) {
+	oldRedirect, oldShorten, oldAdmin := config.RateLimitRedirect, config.RateLimitShorten, config.RateLimitAdmin
+	config.RateLimitRedirect, config.RateLimitShorten, config.RateLimitAdmin = redirect, shorten, admin
+	t.Cleanup(func() {
+		config.RateLimitRedirect, config.RateLimitShorten, config.RateLimitAdmin = oldRedirect, oldShorten, oldAdmin
+	})
+}
+
+// Each group runs out of tokens on its own
+func Test_RateLimitGroups(t *testing.T) {
+	setRateLimits(t, 5, 1, 2)
+
+	testConnect := NewConnection(storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"}))
+	ts := httptest.NewServer(LaunchMyRouter(testConnect))
+	defer ts.Close()
+
+	// shorten: one request per second
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/", body: bytes.NewBufferString("https://practicum.net")})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp.Body.Close()
+	resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/api/shorten", body: bytes.NewBufferString(`{"url": "https://practicum.net"}`)})
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	resp.Body.Close()
+
+	// redirect: shorten is exhausted but the redirects still pass up to their own limit
+	for i := 0; i < 5; i++ {
+		resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/sharaga"})
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		resp.Body.Close()
+	}
+	resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/sharaga"})
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	resp.Body.Close()
+
+	// admin: no routes yet, check the middleware itself
+	limiters := newRateLimiters()
+	admin := limiters.Middleware(groupAdmin)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+	wantCodes := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for _, wantCode := range wantCodes {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin", nil))
+		require.Equal(t, wantCode, rec.Code)
+	}
+}
+
+// Zero limit means no limit and clients don't share the buckets
+func Test_RateLimitersAllow(t *testing.T) {
+	setRateLimits(t, 0, 1, 0)
+	limiters := newRateLimiters()
+
+	for i := 0; i < 100; i++ {
+		require.True(t, limiters.Allow(groupRedirect, "1.1.1.1"))
+	}
+	require.True(t, limiters.Allow(groupShorten, "1.1.1.1"))
+	require.False(t, limiters.Allow(groupShorten, "1.1.1.1"))
+	require.True(t, limiters.Allow(groupShorten, "2.2.2.2"))
+}
+
+// The buckets of the silent clients are dropped, the active ones are kept
+func Test_RateLimitersEvictIdle(t *testing.T) {
+	setRateLimits(t, 0, 1, 0)
+	limiters := newRateLimiters()
+	now := time.Now()
+	limiters.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		limiters.Allow(groupShorten, strconv.Itoa(i))
+	}
+	require.Len(t, limiters.limiters, 100)
+
+	now = now.Add(limiterIdle / 2)
+	limiters.Allow(groupShorten, "0") // active
+	now = now.Add(limiterIdle / 2)
+	limiters.Allow(groupShorten, "new")
+	require.Len(t, limiters.limiters, 2)
+	require.Contains(t, limiters.limiters, groupShorten+"|0")
+}
+
+// Behind the trusted proxy every forwarded client has its own bucket,
+// otherwise they all share the one of the proxy address
+func Test_RateLimitForwardedClients(t *testing.T) {
+	tests := []struct {
+		Name       string
+		TrustProxy bool
+		WantCodes  []int
+	}{
+		{Name: "Trusted proxy", TrustProxy: true, WantCodes: []int{http.StatusOK, http.StatusOK}},
+		{Name: "Untrusted proxy", TrustProxy: false, WantCodes: []int{http.StatusOK, http.StatusTooManyRequests}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			setRateLimits(t, 0, 0, 1)
+			setTrustProxy(t, tc.TrustProxy)
+			limited := newRateLimiters().Middleware(groupAdmin)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				res.WriteHeader(http.StatusOK)
+			}))
+			for i, client := range []string{"10.0.0.1", "10.0.0.2"} {
+				req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+				req.Header.Set("X-Forwarded-For", client)
+				rec := httptest.NewRecorder()
+				limited.ServeHTTP(rec, req)
+				require.Equal(t, tc.WantCodes[i], rec.Code)
+			}
+		})
+	}
+}
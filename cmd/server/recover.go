@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// recoverPanic turns a panic of the handler into 500 with the stack in the log,
+// the server goes on. An answer already started can only be cut short.
+func recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler { // the deliberate abort of the net/http
+				panic(p)
+			}
+			logger.Sugar().Errorw("Handler panic",
+				"Request ID", middleware.GetReqID(req.Context()),
+				"Panic", p,
+				"Stack", string(debug.Stack()),
+			)
+			if logRW, ok := res.(*ResLogOrCompress); ok && logRW.data.code != 0 {
+				return
+			}
+			writeJSONError(res, http.StatusInternalServerError, "Internal server error")
+		}()
+		next.ServeHTTP(res, req)
+	})
+}
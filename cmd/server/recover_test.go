@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RecoverPanic(t *testing.T) {
+	logs := observeLogs(t)
+
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID, checkURL, recoverPanic)
+	router.Get("/{id}", func(res http.ResponseWriter, req *http.Request) {
+		if chi.URLParam(req, "id") == "panic" {
+			var m map[string]string
+			m["id"] = "sharaga" // nil map write
+		}
+		res.WriteHeader(http.StatusOK)
+	})
+	router.Get("/api/expand/{id}", func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+		panic("after the headers")
+	})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/panic"})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	panics := logs.FilterMessage("Handler panic").All()
+	require.Len(t, panics, 1)
+	require.Contains(t, panics[0].ContextMap()["Stack"], "recover_test.go")
+
+	// the started answer keeps its status
+	resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/api/expand/sharaga"})
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, logs.FilterMessage("Handler panic").All(), 2)
+
+	// the server is still up
+	resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/sharaga"})
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
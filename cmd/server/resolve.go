@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/absurd678/skill/internal/models"
+)
+
+// ResolveBatchHandler resolves ["id", ...] in one round trip and answers
+// [{"id": "...", "original_url": "...", "found": bool}, ...] in the same order
+func (c *Connection) ResolveBatchHandler(res http.ResponseWriter, req *http.Request) {
+	var ids []string
+	if err := decodeJSON(req.Body, &ids); err != nil {
+		writeBodyError(res, err, "Invalid JSON")
+		return
+	}
+	if len(ids) == 0 {
+		writeJSONError(res, http.StatusBadRequest, "Empty batch")
+		return
+	}
+
+	resolutions := make([]models.Resolution, 0, len(ids))
+	for _, id := range ids {
+		resolutions = append(resolutions, c.resolve(req, id))
+	}
+	if contextDone(res, req) {
+		return
+	}
+	buff, err := json.MarshalIndent(resolutions, "", " ")
+	if err != nil {
+		writeJSONError(res, http.StatusBadRequest, "Unmarshable data")
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(buff)
+}
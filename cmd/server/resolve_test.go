@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ResolveBatchHandler(t *testing.T) {
+	store := storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru", "old": "https://practicum.net"})
+	require.NoError(t, store.Delete(context.Background(), []string{"old"}))
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+	defer ts.Close()
+
+	tests := []struct {
+		Name     string
+		Body     string
+		WantCode int
+		WantBody string
+	}{
+		{
+			Name:     "Known, unknown and deleted",
+			Body:     `["sharaga", "test", "old"]`,
+			WantCode: http.StatusOK,
+			WantBody: `[{"id": "sharaga", "original_url": "https://mai.ru", "found": true},
+				{"id": "test", "original_url": "", "found": false},
+				{"id": "old", "original_url": "", "found": false}]`,
+		},
+		{
+			Name:     "Repeated id",
+			Body:     `["sharaga", "sharaga"]`,
+			WantCode: http.StatusOK,
+			WantBody: `[{"id": "sharaga", "original_url": "https://mai.ru", "found": true},
+				{"id": "sharaga", "original_url": "https://mai.ru", "found": true}]`,
+		},
+		{
+			Name:     "Empty batch",
+			Body:     `[]`,
+			WantCode: http.StatusBadRequest,
+			WantBody: `{"error": "Empty batch"}`,
+		},
+		{
+			Name:     "Invalid JSON",
+			Body:     `{"id": "sharaga"}`,
+			WantCode: http.StatusBadRequest,
+			WantBody: `{"error": "Invalid JSON"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/resolve/batch", bytes.NewBufferString(tc.Body))
+			require.NoError(t, err)
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.WantBody, string(body))
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/absurd678/skill/internal/storage"
+)
+
+// seedID is an id reachable by the GET /{id} route
+var seedID = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+
+// seedURLs loads the {"id": "original url", ...} file into the storage and
+// returns the number of the links saved. A link that is invalid or clashes
+// with a stored one is logged and skipped, only an unreadable file fails.
+func seedURLs(ctx context.Context, store storage.Storage, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var links map[string]string
+	if err = json.Unmarshal(data, &links); err != nil {
+		return 0, err
+	}
+
+	ids := make([]string, 0, len(links))
+	for id := range links {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids) // the same file clashes the same way every start
+
+	sugar := logger.Sugar()
+	seeded := 0
+	for _, id := range ids {
+		original := links[id]
+		if !seedID.MatchString(id) || !validURL(original) {
+			sugar.Warnw("Seed link skipped: invalid", "Short URL", id, "Original URL", original)
+			continue
+		}
+		original = normalizeURL(original) // like the shortened ones, so they are found
+		got, existed, err := store.GetOrCreate(ctx, id, original)
+		switch {
+		case errors.Is(err, storage.ErrIDTaken):
+			stored, _ := store.Get(ctx, id)
+			sugar.Warnw("Seed link skipped: the id is taken", "Short URL", id, "Original URL", original, "Stored URL", stored)
+		case err != nil:
+			return seeded, err
+		case existed && got != id:
+			sugar.Warnw("Seed link skipped: the url is shortened already", "Short URL", id, "Original URL", original, "Stored ID", got)
+		case existed: // seeded at an earlier start
+		default:
+			seeded++
+		}
+	}
+	return seeded, nil
+}
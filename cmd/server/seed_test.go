@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSeedFile writes the seed file in the test dir
+func writeSeedFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "seed.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func Test_SeedURLs(t *testing.T) {
+	logs := observeLogs(t)
+	store := storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+	path := writeSeedFile(t, `{
+		"docs": "HTTPS://Practicum.net:443/docs",
+		"blog": "https://practicum.net/blog",
+		"sharaga": "https://mephi.ru",
+		"mai": "HTTPS://MAI.ru/",
+		"bad id": "https://practicum.net",
+		"relative": "/docs"
+	}`)
+
+	seeded, err := seedURLs(context.Background(), store, path)
+	require.NoError(t, err)
+	require.Equal(t, 2, seeded)
+	require.Len(t, logs.FilterMessage("Seed link skipped: the id is taken").All(), 1)
+	require.Len(t, logs.FilterMessage("Seed link skipped: the url is shortened already").All(), 1)
+	require.Len(t, logs.FilterMessage("Seed link skipped: invalid").All(), 2)
+
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+	defer ts.Close()
+	tests := []struct {
+		Path         string
+		WantCode     int
+		WantLocation string
+	}{
+		{Path: "/docs", WantCode: http.StatusTemporaryRedirect, WantLocation: "https://practicum.net/docs"},
+		{Path: "/blog", WantCode: http.StatusTemporaryRedirect, WantLocation: "https://practicum.net/blog"},
+		{Path: "/sharaga", WantCode: http.StatusTemporaryRedirect, WantLocation: "https://mai.ru"}, // kept
+		{Path: "/mai", WantCode: http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Path, func(t *testing.T) {
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: tc.Path})
+			resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			require.Equal(t, tc.WantLocation, resp.Header.Get("Location"))
+		})
+	}
+
+	// the seeded urls are normalized like the shortened ones
+	id, existed, err := NewConnection(store).shorten(context.Background(), "https://practicum.net/docs")
+	require.NoError(t, err)
+	require.True(t, existed)
+	require.Equal(t, "docs", id)
+
+	// the next start finds them seeded
+	seeded, err = seedURLs(context.Background(), store, path)
+	require.NoError(t, err)
+	require.Zero(t, seeded)
+}
+
+func Test_SeedURLsInvalidFile(t *testing.T) {
+	store := storage.NewMemStorage(nil)
+	_, err := seedURLs(context.Background(), store, filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err)
+	_, err = seedURLs(context.Background(), store, writeSeedFile(t, `["https://practicum.net"]`))
+	require.Error(t, err)
+}
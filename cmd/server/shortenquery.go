@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// validURL accepts the absolute http(s) urls with a host
+func validURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// GetHandlerShorten shortens GET /api/shorten?url=... for the clients able to
+// send GET only and answers like POST /api/shorten
+func (c *Connection) GetHandlerShorten(res http.ResponseWriter, req *http.Request) {
+	original := req.URL.Query().Get("url")
+	if original == "" {
+		writeJSONError(res, http.StatusBadRequest, "The url parameter is required")
+		return
+	}
+	if !validURL(original) {
+		writeJSONError(res, http.StatusBadRequest, "The url parameter must be an absolute http(s) URL")
+		return
+	}
+	id, existed, err := c.shorten(req.Context(), original)
+	if contextDone(res, req) {
+		return
+	}
+	if err != nil {
+		writeShortenError(res, err)
+		return
+	}
+	writeShortURL(res, id, existed)
+}
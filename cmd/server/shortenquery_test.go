@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GetHandlerShorten(t *testing.T) {
+	oldShortID := newShortID
+	newShortID = func() string { return "first" }
+	defer func() { newShortID = oldShortID }()
+
+	tests := []struct {
+		Name     string
+		Query    string
+		WantCode int
+		WantBody string
+	}{
+		{
+			Name:     "Valid",
+			Query:    "?url=" + url.QueryEscape("https://practicum.net/courses?page=2"),
+			WantCode: http.StatusCreated,
+			WantBody: `{"result": "first"}`,
+		},
+		{
+			Name:     "Already shortened",
+			Query:    "?url=https://mai.ru",
+			WantCode: http.StatusConflict,
+			WantBody: `{"result": "sharaga"}`,
+		},
+		{
+			Name:     "Missing",
+			WantCode: http.StatusBadRequest,
+			WantBody: `{"error": "The url parameter is required"}`,
+		},
+		{
+			Name:     "Relative",
+			Query:    "?url=practicum.net",
+			WantCode: http.StatusBadRequest,
+			WantBody: `{"error": "The url parameter must be an absolute http(s) URL"}`,
+		},
+		{
+			Name:     "Not http",
+			Query:    "?url=" + url.QueryEscape("javascript:alert(1)"),
+			WantCode: http.StatusBadRequest,
+			WantBody: `{"error": "The url parameter must be an absolute http(s) URL"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			store := storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+			defer ts.Close()
+
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/api/shorten" + tc.Query})
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.WantBody, string(body))
+			if tc.WantCode == http.StatusCreated {
+				original, ok := store.Get(context.Background(), "first")
+				require.True(t, ok)
+				require.Equal(t, "https://practicum.net/courses?page=2", original)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// StatsHandler answers {"urls": <count>, "users": <count>}, the users are 0 until they are tracked
+func (c *Connection) StatsHandler(res http.ResponseWriter, req *http.Request) {
+	stats, err := c.store.Stats(req.Context())
+	if contextDone(res, req) {
+		return
+	}
+	if err != nil {
+		writeStorageError(res, err)
+		return
+	}
+	buff, err := json.Marshal(stats)
+	if err != nil {
+		writeJSONError(res, http.StatusInternalServerError, "Unmarshable data")
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(buff)
+}
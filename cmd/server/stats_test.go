@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_StatsHandler(t *testing.T) {
+	oldSubnet := config.TrustedSubnet
+	defer func() { config.TrustedSubnet = oldSubnet }()
+
+	tests := []struct {
+		Name      string
+		Subnet    string
+		WantCode  int
+		WantStats string
+	}{
+		{
+			Name:      "Trusted",
+			Subnet:    "127.0.0.0/8",
+			WantCode:  http.StatusOK,
+			WantStats: `{"urls": 3, "users": 0}`, // the deleted one isn't counted
+		},
+		{
+			Name:     "Outside the trusted subnet",
+			Subnet:   "10.0.0.0/8",
+			WantCode: http.StatusForbidden,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			config.TrustedSubnet = tc.Subnet
+			store := storage.NewMemStorage(map[string]string{"sharaga": "https://mai.ru", "old": "https://mai.ru/old"})
+			require.NoError(t, store.Delete(context.Background(), []string{"old"}))
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(store)))
+			defer ts.Close()
+
+			// two more urls
+			for _, original := range []string{"https://practicum.net", "https://ilovespb.ru"} {
+				resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/", body: bytes.NewBufferString(original)})
+				resp.Body.Close()
+				require.Equal(t, http.StatusCreated, resp.StatusCode)
+			}
+
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/api/internal/stats"})
+			defer resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			if tc.WantCode == http.StatusOK {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.JSONEq(t, tc.WantStats, string(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/absurd678/skill/cmd/config"
+)
+
+// clientIP is the connection address or, behind the trusted proxy, the client
+// it reports: X-Real-IP, else the last X-Forwarded-For hop the proxy appended
+func clientIP(req *http.Request) net.IP {
+	if config.TrustProxy {
+		if ip := net.ParseIP(strings.TrimSpace(req.Header.Get("X-Real-IP"))); ip != nil {
+			return ip
+		}
+		if hops := strings.Split(req.Header.Get("X-Forwarded-For"), ","); len(hops) > 0 {
+			if ip := net.ParseIP(strings.TrimSpace(hops[len(hops)-1])); ip != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// trustedSubnet lets only the clients from the CIDR to the internal routes,
+// with no (or invalid) CIDR the routes are closed for everyone
+func trustedSubnet(cidr string) func(http.Handler) http.Handler {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		subnet = nil
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			ip := clientIP(req)
+			if subnet == nil || ip == nil || !subnet.Contains(ip) {
+				writeJSONError(res, http.StatusForbidden, "Forbidden")
+				return
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// setTrustProxy sets config.TrustProxy for the test and restores it after
+func setTrustProxy(t *testing.T, trust bool) {
+	oldTrust := config.TrustProxy
+	config.TrustProxy = trust
+	t.Cleanup(func() { config.TrustProxy = oldTrust })
+}
+
+func Test_ClientIP(t *testing.T) {
+	tests := []struct {
+		Name       string
+		TrustProxy bool
+		RealIP     string
+		Forwarded  string
+		WantIP     string
+	}{
+		{Name: "Direct", WantIP: "192.0.2.1"},
+		{Name: "Untrusted X-Real-IP", RealIP: "10.0.0.1", WantIP: "192.0.2.1"},
+		{Name: "Untrusted X-Forwarded-For", Forwarded: "10.0.0.1", WantIP: "192.0.2.1"},
+		{Name: "Trusted X-Real-IP", TrustProxy: true, RealIP: "10.0.0.1", Forwarded: "10.0.0.2", WantIP: "10.0.0.1"},
+		{Name: "Trusted X-Forwarded-For", TrustProxy: true, Forwarded: "10.0.0.1", WantIP: "10.0.0.1"},
+		{Name: "Spoofed X-Forwarded-For hop", TrustProxy: true, Forwarded: "127.0.0.1, 10.0.0.2", WantIP: "10.0.0.2"},
+		{Name: "Trusted proxy without headers", TrustProxy: true, WantIP: "192.0.2.1"},
+		{Name: "Invalid trusted header", TrustProxy: true, RealIP: "unknown", Forwarded: "unknown", WantIP: "192.0.2.1"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			setTrustProxy(t, tc.TrustProxy)
+			req := httptest.NewRequest(http.MethodGet, "/", nil) // RemoteAddr 192.0.2.1:1234
+			if tc.RealIP != "" {
+				req.Header.Set("X-Real-IP", tc.RealIP)
+			}
+			if tc.Forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.Forwarded)
+			}
+			require.Equal(t, tc.WantIP, clientIP(req).String())
+		})
+	}
+}
+
+func Test_TrustedSubnet(t *testing.T) {
+	oldSubnet := config.TrustedSubnet
+	defer func() { config.TrustedSubnet = oldSubnet }()
+
+	tests := []struct {
+		Name       string
+		Subnet     string
+		TrustProxy bool
+		RealIP     string
+		Method     string
+		Path       string
+		WantCode   int
+	}{
+		{
+			Name:       "Allowed X-Real-IP",
+			Subnet:     "192.168.1.0/24",
+			TrustProxy: true,
+			RealIP:     "192.168.1.10",
+			Method:     http.MethodGet,
+			Path:       "/api/internal/stats",
+			WantCode:   http.StatusOK,
+		},
+		{
+			Name:     "X-Real-IP without the trusted proxy",
+			Subnet:   "192.168.1.0/24",
+			RealIP:   "192.168.1.10",
+			Method:   http.MethodGet,
+			Path:     "/api/internal/stats",
+			WantCode: http.StatusForbidden,
+		},
+		{
+			Name:     "Allowed RemoteAddr",
+			Subnet:   "127.0.0.0/8",
+			Method:   http.MethodDelete,
+			Path:     "/api/internal/banner",
+			WantCode: http.StatusNoContent,
+		},
+		{
+			Name:       "Denied X-Real-IP",
+			Subnet:     "192.168.1.0/24",
+			TrustProxy: true,
+			RealIP:     "10.0.0.1",
+			Method:     http.MethodGet,
+			Path:       "/api/internal/stats",
+			WantCode:   http.StatusForbidden,
+		},
+		{
+			Name:     "Denied RemoteAddr",
+			Subnet:   "192.168.1.0/24",
+			Method:   http.MethodPut,
+			Path:     "/api/internal/banner",
+			WantCode: http.StatusForbidden,
+		},
+		{
+			Name:     "Unconfigured",
+			Subnet:   "",
+			RealIP:   "127.0.0.1",
+			Method:   http.MethodGet,
+			Path:     "/api/internal/stats",
+			WantCode: http.StatusForbidden,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			config.TrustedSubnet = tc.Subnet
+			setTrustProxy(t, tc.TrustProxy)
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+			defer ts.Close()
+
+			req, err := http.NewRequest(tc.Method, ts.URL+tc.Path, nil)
+			require.NoError(t, err)
+			if tc.RealIP != "" {
+				req.Header.Set("X-Real-IP", tc.RealIP)
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/models"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingStore hangs the calls until the request context is done
+type blockingStore struct {
+	storage.Storage // not used by the tested handlers
+}
+
+func (blockingStore) Save(ctx context.Context, shortURL, originalURL string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockingStore) Get(ctx context.Context, shortURL string) (string, bool) {
+	<-ctx.Done()
+	return "", false
+}
+
+func (blockingStore) Lookup(ctx context.Context, shortURL string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func (blockingStore) Exists(ctx context.Context, shortURL string) bool {
+	<-ctx.Done()
+	return false
+}
+
+func (blockingStore) GetByOriginal(ctx context.Context, originalURL string) (string, bool) {
+	<-ctx.Done()
+	return "", false
+}
+
+func (blockingStore) GetOrCreate(ctx context.Context, shortURL, originalURL string) (string, bool, error) {
+	<-ctx.Done()
+	return "", false, ctx.Err()
+}
+
+func (blockingStore) Stats(ctx context.Context) (models.Stats, error) {
+	<-ctx.Done()
+	return models.Stats{}, ctx.Err()
+}
+
+func Test_RequestTimeout(t *testing.T) {
+	oldTimeout := config.RequestTimeout
+	config.RequestTimeout = 50 * time.Millisecond
+	defer func() { config.RequestTimeout = oldTimeout }()
+
+	ts := httptest.NewServer(LaunchMyRouter(NewConnection(blockingStore{})))
+	defer ts.Close()
+
+	tests := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+	}{
+		{Name: "GET", Method: http.MethodGet, Path: "/sharaga"},
+		{Name: "POST", Method: http.MethodPost, Path: "/", Body: "https://practicum.net"},
+		{Name: "POST JSON", Method: http.MethodPost, Path: "/api/shorten", Body: `{"url": "https://practicum.net"}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			start := time.Now()
+			resp := testRequest(testRequestOptions{
+				t:      t,
+				ts:     ts,
+				method: tc.Method,
+				path:   tc.Path,
+				body:   bytes.NewBufferString(tc.Body),
+			})
+			resp.Body.Close()
+			require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+			require.Less(t, time.Since(start), 5*time.Second)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/absurd678/skill/internal/models"
+)
+
+// Set at the build:
+// go build -ldflags "-X main.buildVersion=v1.0.0 -X 'main.buildDate=$(date)' -X main.buildCommit=$(git rev-parse --short HEAD)"
+var (
+	buildVersion string
+	buildDate    string
+	buildCommit  string
+)
+
+// buildInfo has N/A for the values not set by the linker
+func buildInfo() models.BuildInfo {
+	orNA := func(value string) string {
+		if value == "" {
+			return "N/A"
+		}
+		return value
+	}
+	return models.BuildInfo{Version: orNA(buildVersion), Date: orNA(buildDate), Commit: orNA(buildCommit)}
+}
+
+func printBuildInfo(w io.Writer) {
+	info := buildInfo()
+	fmt.Fprintf(w, "Build version: %s\nBuild date: %s\nBuild commit: %s\n", info.Version, info.Date, info.Commit)
+}
+
+// VersionHandler answers the build info as JSON
+func (c *Connection) VersionHandler(res http.ResponseWriter, req *http.Request) {
+	buff, err := json.Marshal(buildInfo())
+	if err != nil {
+		writeJSONError(res, http.StatusInternalServerError, "Unmarshable data")
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(buff)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_VersionHandler(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Version  string
+		Date     string
+		Commit   string
+		WantBody string
+	}{
+		{
+			Name:     "Not set",
+			WantBody: `{"version": "N/A", "date": "N/A", "commit": "N/A"}`,
+		},
+		{
+			Name:     "Set by the linker",
+			Version:  "v1.2.0",
+			Date:     "2024-05-01",
+			Commit:   "fb7627b",
+			WantBody: `{"version": "v1.2.0", "date": "2024-05-01", "commit": "fb7627b"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			oldVersion, oldDate, oldCommit := buildVersion, buildDate, buildCommit
+			buildVersion, buildDate, buildCommit = tc.Version, tc.Date, tc.Commit
+			defer func() { buildVersion, buildDate, buildCommit = oldVersion, oldDate, oldCommit }()
+
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+			defer ts.Close()
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/api/version"})
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.WantBody, string(body))
+		})
+	}
+}
+
+func Test_PrintBuildInfo(t *testing.T) {
+	oldVersion, oldDate, oldCommit := buildVersion, buildDate, buildCommit
+	buildVersion, buildDate, buildCommit = "v1.2.0", "", "fb7627b"
+	defer func() { buildVersion, buildDate, buildCommit = oldVersion, oldDate, oldCommit }()
+
+	var out bytes.Buffer
+	printBuildInfo(&out)
+	require.Equal(t, "Build version: v1.2.0\nBuild date: N/A\nBuild commit: fb7627b\n", out.String())
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// watchdogProbePath is served by the router like any client request, an
+// unknown id still takes the storage lock
+const watchdogProbePath = "/api/expand/watchdog"
+
+// watchdogProbeKey marks the probe request, the access log and the metrics skip it
+type watchdogProbeKey struct{}
+
+func isWatchdogProbe(req *http.Request) bool {
+	return req.Context().Value(watchdogProbeKey{}) != nil
+}
+
+// Watchdog periodically sends a self-request to the router and flags the
+// server stalled when it isn't answered within the timeout (e.g. a deadlock)
+type Watchdog struct {
+	handler http.Handler
+	timeout time.Duration
+	probing atomic.Bool // the last probe hasn't returned yet
+	stalled atomic.Bool
+}
+
+func newWatchdog(handler http.Handler, timeout time.Duration) *Watchdog {
+	return &Watchdog{handler: handler, timeout: timeout}
+}
+
+// Stalled reports whether the last probe timed out
+func (w *Watchdog) Stalled() bool {
+	return w.stalled.Load()
+}
+
+// Run probes every interval until ctx is done
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check sends one probe, a probe still hanging from before means stalled
+func (w *Watchdog) check() {
+	if !w.probing.CompareAndSwap(false, true) {
+		w.setStalled(true)
+		return
+	}
+	ctx := context.WithValue(context.Background(), watchdogProbeKey{}, true)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, watchdogProbePath, nil)
+	if err != nil {
+		w.probing.Store(false)
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer w.probing.Store(false)
+		defer close(done)
+		w.handler.ServeHTTP(discardWriter{header: http.Header{}}, req)
+	}()
+
+	select {
+	case <-done:
+		w.setStalled(false)
+	case <-time.After(w.timeout):
+		w.setStalled(true)
+	}
+}
+
+func (w *Watchdog) setStalled(stalled bool) {
+	if w.stalled.Swap(stalled) == stalled {
+		return // log the changes only
+	}
+	if stalled {
+		logger.Sugar().Errorw("Watchdog: self-request not answered", "Timeout", w.timeout)
+	} else {
+		logger.Sugar().Infow("Watchdog: self-request answered again")
+	}
+}
+
+// discardWriter drops the probe response
+type discardWriter struct {
+	header http.Header
+}
+
+func (d discardWriter) Header() http.Header         { return d.header }
+func (d discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardWriter) WriteHeader(int)             {}
+
+// LivezHandler answers 200 while the watchdog (if any) sees the server alive, else 503
+func (c *Connection) LivezHandler(res http.ResponseWriter, req *http.Request) {
+	if c.watchdog != nil && c.watchdog.Stalled() {
+		writeJSONError(res, http.StatusServiceUnavailable, "Server is stalled")
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte(`{"status":"ok"}`))
+}
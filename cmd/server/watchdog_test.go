@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Watchdog(t *testing.T) {
+	release := make(chan struct{})
+	stalledHandler := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		<-release // e.g. waiting for a storage lock
+	})
+	w := newWatchdog(stalledHandler, 20*time.Millisecond)
+
+	w.check()
+	require.True(t, w.Stalled())
+	w.check() // the first probe still hangs
+	require.True(t, w.Stalled())
+
+	close(release)
+	require.Eventually(t, func() bool { return !w.probing.Load() }, time.Second, 5*time.Millisecond)
+	w.check()
+	require.False(t, w.Stalled())
+}
+
+func Test_LivezHandler(t *testing.T) {
+	c := NewConnection(storage.NewMemStorage(nil))
+	router := LaunchMyRouter(c)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/livez"})
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode) // no watchdog
+
+	// the real router answers the probe
+	c.watchdog = newWatchdog(router, time.Second)
+	c.watchdog.check()
+	resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/livez"})
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// a stalled handler trips it
+	release := make(chan struct{})
+	defer close(release)
+	c.watchdog = newWatchdog(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) { <-release }), 10*time.Millisecond)
+	c.watchdog.check()
+	resp = testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/livez"})
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+// The probes go through the router without access log entries and request metrics
+func Test_WatchdogProbeUnlogged(t *testing.T) {
+	oldSubnet := config.TrustedSubnet
+	config.TrustedSubnet = "127.0.0.0/8"
+	defer func() { config.TrustedSubnet = oldSubnet }()
+	logs := observeLogs(t)
+
+	router := LaunchMyRouter(NewConnection(storage.NewMemStorage(nil)))
+	w := newWatchdog(router, time.Second)
+	for i := 0; i < 3; i++ {
+		w.check()
+	}
+	require.False(t, w.Stalled())
+	require.Zero(t, logs.FilterMessage("Request parameters").Len())
+	require.Zero(t, logs.FilterMessage("Response parameters").Len())
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/metrics"})
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotContains(t, string(body), `route="/api/expand/{id}"`)
+}
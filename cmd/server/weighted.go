@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+
+	"github.com/absurd678/skill/internal/models"
+	"github.com/absurd678/skill/internal/storage"
+)
+
+// The weights are capped so their sum always fits an int
+const (
+	maxTargets      = 100
+	maxTargetWeight = 1_000_000
+)
+
+// pickTarget chooses the target with probability weight / sum of the weights,
+// the first one when there are no positive weights
+func pickTarget(targets []models.Target) string {
+	total := 0
+	for _, target := range targets {
+		total += max(target.Weight, 0)
+	}
+	if total <= 0 {
+		return targets[0].URL
+	}
+	n := rand.Intn(total)
+	for _, target := range targets {
+		if n < target.Weight {
+			return target.URL
+		}
+		n -= max(target.Weight, 0)
+	}
+	return targets[len(targets)-1].URL
+}
+
+// checkTargets answers the error of invalid targets and normalizes the valid urls
+func checkTargets(targets []models.Target) (string, bool) {
+	if len(targets) == 0 {
+		return "No targets", false
+	}
+	if len(targets) > maxTargets {
+		return fmt.Sprintf("At most %d targets", maxTargets), false
+	}
+	for i, target := range targets {
+		if !validURL(target.URL) || target.Weight <= 0 || target.Weight > maxTargetWeight {
+			return fmt.Sprintf("Every target needs an absolute http(s) url and a weight from 1 to %d", maxTargetWeight), false
+		}
+		targets[i].URL = normalizeURL(target.URL)
+	}
+	return "", true
+}
+
+// PostHandlerWeighted shortens {"targets": [{"url": "...", "weight": 1}, ...]}
+func (c *Connection) PostHandlerWeighted(res http.ResponseWriter, req *http.Request) {
+	var weighted models.WeightedURL
+	if err := decodeJSON(req.Body, &weighted); err != nil {
+		writeBodyError(res, err, "Invalid JSON")
+		return
+	}
+	if message, ok := checkTargets(weighted.Targets); !ok {
+		writeJSONError(res, http.StatusBadRequest, message)
+		return
+	}
+
+	id, err := c.saveTargets(req.Context(), weighted.Targets)
+	if contextDone(res, req) {
+		return
+	}
+	if err != nil {
+		writeShortenError(res, err)
+		return
+	}
+
+	buff, err := json.MarshalIndent(models.ShortURL{URL: id}, "", " ")
+	if err != nil {
+		writeJSONError(res, http.StatusBadRequest, "Unmarshable data")
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusCreated)
+	res.Write(buff)
+}
+
+// saveTargets stores the targets under a new id, the taken ids are retried
+func (c *Connection) saveTargets(ctx context.Context, targets []models.Target) (string, error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		id, err := c.candidateID(ctx)
+		if err != nil {
+			return "", err
+		}
+		err = c.store.SaveTargets(ctx, id, targets)
+		if !errors.Is(err, storage.ErrIDTaken) {
+			return id, err
+		}
+	}
+	return "", errIDsExhausted
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/absurd678/skill/cmd/config"
+	"github.com/absurd678/skill/internal/models"
+	"github.com/absurd678/skill/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// The long-run share of every target is close to its weight
+func Test_PickTargetDistribution(t *testing.T) {
+	targets := []models.Target{
+		{URL: "https://a.example", Weight: 1},
+		{URL: "https://b.example", Weight: 3},
+		{URL: "https://c.example", Weight: 6},
+	}
+	const n = 100000
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		counts[pickTarget(targets)]++
+	}
+	for _, target := range targets {
+		require.InDelta(t, float64(target.Weight)/10, float64(counts[target.URL])/n, 0.02, target.URL)
+	}
+}
+
+func Test_PostHandlerWeighted(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Body     string
+		WantCode int
+	}{
+		{
+			Name:     "OK",
+			Body:     `{"targets": [{"url": "https://a.example", "weight": 1}, {"url": "https://b.example", "weight": 1}]}`,
+			WantCode: http.StatusCreated,
+		},
+		{
+			Name:     "No targets",
+			Body:     `{"targets": []}`,
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "Zero weight",
+			Body:     `{"targets": [{"url": "https://a.example", "weight": 0}]}`,
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "Weight too large",
+			Body:     `{"targets": [{"url": "https://a.example", "weight": 9223372036854775807}, {"url": "https://b.example", "weight": 1}]}`,
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "Too many targets",
+			Body:     `{"targets": [` + strings.Repeat(`{"url": "https://a.example", "weight": 1},`, maxTargets) + `{"url": "https://b.example", "weight": 1}]}`,
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "Not a URL",
+			Body:     `{"targets": [{"url": "https://a.example", "weight": 1}, {"url": "javascript:alert(1)", "weight": 1}]}`,
+			WantCode: http.StatusBadRequest,
+		},
+		{
+			Name:     "Normalized",
+			Body:     `{"targets": [{"url": "HTTPS://A.example:443/", "weight": 1}, {"url": "https://b.example", "weight": 1}]}`,
+			WantCode: http.StatusCreated,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			ts := httptest.NewServer(LaunchMyRouter(NewConnection(storage.NewMemStorage(nil))))
+			defer ts.Close()
+
+			resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodPost, path: "/api/shorten/weighted", body: bytes.NewBufferString(tc.Body)})
+			defer resp.Body.Close()
+			require.Equal(t, tc.WantCode, resp.StatusCode)
+			if tc.WantCode != http.StatusCreated {
+				return
+			}
+
+			var short models.ShortURL
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&short))
+			// both targets are reached by the redirects
+			seen := map[string]bool{}
+			for i := 0; i < 100; i++ {
+				resp := testRequest(testRequestOptions{t: t, ts: ts, method: http.MethodGet, path: "/" + short.URL})
+				resp.Body.Close()
+				require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+				seen[resp.Header.Get("Location")] = true
+			}
+			require.Equal(t, map[string]bool{"https://a.example": true, "https://b.example": true}, seen)
+		})
+	}
+}
+
+// Without a positive weight the first target is taken instead of a panic
+func Test_PickTargetNoWeights(t *testing.T) {
+	targets := []models.Target{{URL: "https://a.example", Weight: 0}, {URL: "https://b.example", Weight: -1}}
+	require.Equal(t, "https://a.example", pickTarget(targets))
+}
+
+// A taken id is retried, the existing url isn't overwritten
+func Test_SaveTargetsTakenID(t *testing.T) {
+	oldMode := config.ShortIDMode
+	config.ShortIDMode = "counter"
+	defer func() { config.ShortIDMode = oldMode }()
+
+	store := storage.NewMemStorage(map[string]string{counterID(1): "https://mai.ru"})
+	c := NewConnection(store)
+	id, err := c.saveTargets(context.Background(), []models.Target{{URL: "https://a.example", Weight: 1}})
+	require.NoError(t, err)
+	require.Equal(t, counterID(2), id)
+	original, ok := store.Get(context.Background(), counterID(1))
+	require.True(t, ok)
+	require.Equal(t, "https://mai.ru", original)
+}
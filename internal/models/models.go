@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type (
 	SomeURL struct {
 		URL string `json:"url"`
@@ -7,4 +9,92 @@ type (
 	ShortURL struct {
 		URL string `json:"result"`
 	}
+	// Target is a destination of a weighted short url
+	Target struct {
+		URL    string `json:"url"`
+		Weight int    `json:"weight"`
+	}
+	WeightedURL struct {
+		Targets []Target `json:"targets"`
+	}
+	Stats struct {
+		URLs  int `json:"urls"`  // live ones, not the deleted or expired
+		Users int `json:"users"` // 0 until the users are tracked
+	}
+	ErrorResponse struct {
+		Error string `json:"error"`
+	}
+
+	// URLRecord is a line of the file storage, a deleted (removed) record marks
+	// the earlier one deleted (moved to the archive)
+	URLRecord struct {
+		UUID        string     `json:"uuid"`
+		ShortURL    string     `json:"short_url"`
+		OriginalURL string     `json:"original_url"`
+		Targets     []Target   `json:"targets,omitempty"`
+		DeletedFlag bool       `json:"is_deleted,omitempty"`
+		RemovedFlag bool       `json:"is_removed,omitempty"`
+		CreatedAt   *time.Time `json:"created_at,omitempty"` // of the saved records only
+		Seq         uint64     `json:"seq,omitempty"`        // the counter reserved up to, no url in the record
+		Hits        int64      `json:"hits,omitempty"`       // of the archived records only
+	}
+
+	// ExpandedURL is the short url metadata of GET /api/expand/{id}
+	ExpandedURL struct {
+		ShortURL    string    `json:"short_url"`
+		OriginalURL string    `json:"original_url"`
+		Deleted     bool      `json:"deleted"`
+		CreatedAt   time.Time `json:"created_at"`
+		Hits        int64     `json:"hits"`
+	}
+
+	// BatchURL is an item of POST /api/shorten/batch
+	BatchURL struct {
+		CorrelationID string `json:"correlation_id"`
+		OriginalURL   string `json:"original_url"`
+	}
+	// BatchShortURL answers the BatchURL with the same correlation id
+	BatchShortURL struct {
+		CorrelationID string `json:"correlation_id"`
+		ShortURL      string `json:"short_url"`
+	}
+
+	// BatchID is a line of the NDJSON bulk expand request
+	BatchID struct {
+		ID string `json:"id"`
+	}
+	// ExportedURL is a line of the NDJSON export and import, the users are
+	// not tracked so user_id is always empty
+	ExportedURL struct {
+		ID          string     `json:"id"`
+		OriginalURL string     `json:"original_url"`
+		Targets     []Target   `json:"targets,omitempty"`
+		UserID      string     `json:"user_id"`
+		Deleted     bool       `json:"deleted"`
+		CreatedAt   *time.Time `json:"created_at,omitempty"` // the older exports have none, imported as created now
+	}
+	// ImportResult counts the imported lines
+	ImportResult struct {
+		Imported int `json:"imported"`
+	}
+	// Resolution is the short url lookup result, a deleted one isn't found
+	Resolution struct {
+		ID          string `json:"id"`
+		OriginalURL string `json:"original_url"`
+		Found       bool   `json:"found"`
+	}
+
+	// BuildInfo answers GET /api/version, N/A for the values not set at the build
+	BuildInfo struct {
+		Version string `json:"version"`
+		Date    string `json:"date"`
+		Commit  string `json:"commit"`
+	}
+
+	// CachedResponse is the answer replayed to the requests repeating an Idempotency-Key
+	CachedResponse struct {
+		Status int               `json:"status"`
+		Header map[string]string `json:"header,omitempty"`
+		Body   []byte            `json:"body"`
+	}
 )
@@ -0,0 +1,4 @@
+// Package proto keeps the gRPC API of the shortener generated from shortener.proto
+package proto
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative shortener.proto
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+	"go.uber.org/zap"
+)
+
+// Archivable is the hot storage the retention policy moves the old urls out of
+type Archivable interface {
+	Storage
+	// MoveBefore hands the urls created before t to put and drops them, the
+	// storage is locked meanwhile, so no hit, deletion or new url is lost
+	MoveBefore(ctx context.Context, t time.Time, put func(models.URLRecord) error) (int, error)
+}
+
+// Archive is the cold storage the archived urls are moved to, it gets no new urls
+type Archive interface {
+	// Put keeps the archived url as it was in the hot storage: created at, hits and deleted
+	Put(ctx context.Context, record models.URLRecord) error
+	Get(ctx context.Context, shortURL string) (string, bool)
+	Lookup(ctx context.Context, shortURL string) (string, error)
+	Exists(ctx context.Context, shortURL string) bool
+	GetByOriginal(ctx context.Context, originalURL string) (string, bool)
+	Stats(ctx context.Context) (models.Stats, error)
+	GetTargets(ctx context.Context, shortURL string) ([]models.Target, bool)
+	Delete(ctx context.Context, shortURLs []string) error
+	IsDeleted(ctx context.Context, shortURL string) bool
+	CreatedAt(ctx context.Context, shortURL string) (time.Time, bool)
+	Export(ctx context.Context, fn func(models.ExportedURL) error) error
+	Hit(ctx context.Context, shortURL string) error
+	Hits(ctx context.Context, shortURL string) int64
+}
+
+// ----------------------ArchivedStorage----------------------------
+// ArchivedStorage writes to the hot storage and reads through to the archive,
+// Archive moves the urls older than the max age from the hot one to the archive
+type ArchivedStorage struct {
+	hot     Archivable
+	archive Archive
+}
+
+func NewArchivedStorage(hot Archivable, archive Archive) *ArchivedStorage {
+	return &ArchivedStorage{hot: hot, archive: archive}
+}
+
+// Archive moves the urls created before now - maxAge and returns their count,
+// every url is readable from the archive before it's dropped from the hot storage
+func (as *ArchivedStorage) Archive(ctx context.Context, maxAge time.Duration) (int, error) {
+	return as.hot.MoveBefore(ctx, time.Now().Add(-maxAge), func(record models.URLRecord) error {
+		return as.archive.Put(ctx, record)
+	})
+}
+
+// flusher is the archive keeping some writes in memory, like the hit counts
+type flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Run archives every interval until ctx is done
+func (as *ArchivedStorage) Run(ctx context.Context, interval, maxAge time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			archived, err := as.Archive(ctx, maxAge)
+			if err != nil {
+				logger.Sugar().Errorw("Archive failed", "Error", err)
+			} else if archived > 0 {
+				logger.Sugar().Infow("Old urls archived", "Count", archived, "Max age", maxAge)
+			}
+			if archive, ok := as.archive.(flusher); ok {
+				if err = archive.Flush(ctx); err != nil {
+					logger.Sugar().Errorw("Archive flush failed", "Error", err)
+				}
+			}
+		}
+	}
+}
+
+func (as *ArchivedStorage) Save(ctx context.Context, shortURL, originalURL string) error {
+	return as.hot.Save(ctx, shortURL, originalURL)
+}
+
+func (as *ArchivedStorage) Get(ctx context.Context, shortURL string) (string, bool) {
+	if original, ok := as.hot.Get(ctx, shortURL); ok {
+		return original, true
+	}
+	return as.archive.Get(ctx, shortURL)
+}
+
+func (as *ArchivedStorage) Lookup(ctx context.Context, shortURL string) (string, error) {
+	original, err := as.hot.Lookup(ctx, shortURL)
+	if errors.Is(err, ErrNotFound) {
+		return as.archive.Lookup(ctx, shortURL)
+	}
+	return original, err
+}
+
+func (as *ArchivedStorage) Exists(ctx context.Context, shortURL string) bool {
+	_, ok := as.Get(ctx, shortURL)
+	return ok
+}
+
+func (as *ArchivedStorage) GetByOriginal(ctx context.Context, originalURL string) (string, bool) {
+	if shortURL, ok := as.hot.GetByOriginal(ctx, originalURL); ok {
+		return shortURL, true
+	}
+	return as.archive.GetByOriginal(ctx, originalURL)
+}
+
+// GetOrCreate finds the archived original or id first, the hot storage keeps the new ones
+func (as *ArchivedStorage) GetOrCreate(ctx context.Context, shortURL, originalURL string) (string, bool, error) {
+	if existing, ok := as.archive.GetByOriginal(ctx, originalURL); ok {
+		return existing, true, nil
+	}
+	if as.archive.Exists(ctx, shortURL) {
+		return "", false, ErrIDTaken
+	}
+	return as.hot.GetOrCreate(ctx, shortURL, originalURL)
+}
+
+// SaveBatch saves to the hot storage, the taken archived ids fail the batch
+func (as *ArchivedStorage) SaveBatch(ctx context.Context, records []models.URLRecord) error {
+	for _, record := range records {
+		if as.archive.Exists(ctx, record.ShortURL) {
+			return ErrIDTaken
+		}
+	}
+	return as.hot.SaveBatch(ctx, records)
+}
+
+func (as *ArchivedStorage) Stats(ctx context.Context) (models.Stats, error) {
+	hot, err := as.hot.Stats(ctx)
+	if err != nil {
+		return models.Stats{}, err
+	}
+	archived, err := as.archive.Stats(ctx)
+	if err != nil {
+		return models.Stats{}, err
+	}
+	return models.Stats{URLs: hot.URLs + archived.URLs, Users: hot.Users + archived.Users}, nil
+}
+
+// SaveTargets saves to the hot storage, a taken archived id fails
+func (as *ArchivedStorage) SaveTargets(ctx context.Context, shortURL string, targets []models.Target) error {
+	if as.archive.Exists(ctx, shortURL) {
+		return ErrIDTaken
+	}
+	return as.hot.SaveTargets(ctx, shortURL, targets)
+}
+
+// Import saves to the hot storage, a weighted url over a taken archived id fails
+func (as *ArchivedStorage) Import(ctx context.Context, url models.ExportedURL) error {
+	if len(url.Targets) > 0 && as.archive.Exists(ctx, url.ID) {
+		return ErrIDTaken
+	}
+	return as.hot.Import(ctx, url)
+}
+
+func (as *ArchivedStorage) GetTargets(ctx context.Context, shortURL string) ([]models.Target, bool) {
+	if targets, ok := as.hot.GetTargets(ctx, shortURL); ok {
+		return targets, true
+	}
+	return as.archive.GetTargets(ctx, shortURL)
+}
+
+func (as *ArchivedStorage) Delete(ctx context.Context, shortURLs []string) error {
+	if err := as.hot.Delete(ctx, shortURLs); err != nil {
+		return err
+	}
+	return as.archive.Delete(ctx, shortURLs)
+}
+
+func (as *ArchivedStorage) IsDeleted(ctx context.Context, shortURL string) bool {
+	if _, ok := as.hot.Get(ctx, shortURL); ok {
+		return as.hot.IsDeleted(ctx, shortURL)
+	}
+	return as.archive.IsDeleted(ctx, shortURL)
+}
+
+// CreatedAt of an archived url is kept from the hot storage
+func (as *ArchivedStorage) CreatedAt(ctx context.Context, shortURL string) (time.Time, bool) {
+	if created, ok := as.hot.CreatedAt(ctx, shortURL); ok {
+		return created, true
+	}
+	return as.archive.CreatedAt(ctx, shortURL)
+}
+
+// Export goes through the hot storage, then the archive
+func (as *ArchivedStorage) Export(ctx context.Context, fn func(models.ExportedURL) error) error {
+	if err := as.hot.Export(ctx, fn); err != nil {
+		return err
+	}
+	return as.archive.Export(ctx, fn)
+}
+
+// NextSeq is counted by the hot storage, the archive gets no new urls
+func (as *ArchivedStorage) NextSeq(ctx context.Context) (uint64, error) {
+	return as.hot.NextSeq(ctx)
+}
+
+// Hit counts in the storage keeping the url, the archived urls keep their hits
+func (as *ArchivedStorage) Hit(ctx context.Context, shortURL string) error {
+	if as.hot.Exists(ctx, shortURL) {
+		return as.hot.Hit(ctx, shortURL)
+	}
+	return as.archive.Hit(ctx, shortURL)
+}
+
+func (as *ArchivedStorage) Hits(ctx context.Context, shortURL string) int64 {
+	if as.hot.Exists(ctx, shortURL) {
+		return as.hot.Hits(ctx, shortURL)
+	}
+	return as.archive.Hits(ctx, shortURL)
+}
+
+// ----------------------ArchivedStorage----------------------------
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func Test_ArchivedStorage(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Archive func(t *testing.T) Archive
+	}{
+		{Name: "Memory", Archive: func(t *testing.T) Archive { return NewMemStorage(nil) }},
+		{
+			Name: "Disk",
+			Archive: func(t *testing.T) Archive {
+				cold, err := NewColdStorage(filepath.Join(t.TempDir(), "archive.json"), zap.NewNop())
+				require.NoError(t, err)
+				t.Cleanup(func() { cold.Close() })
+				return cold
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			testArchivedStorage(t, tc.Archive(t))
+		})
+	}
+}
+
+func testArchivedStorage(t *testing.T, archive Archive) {
+	ctx := context.Background()
+	hot := NewMemStorage(map[string]string{"sharaga": "https://mai.ru", "fresh": "https://practicum.net"})
+	targets := []models.Target{{URL: "https://a.example", Weight: 1}, {URL: "https://b.example", Weight: 3}}
+	require.NoError(t, hot.SaveTargets(ctx, "ab", targets))
+	require.NoError(t, hot.Save(ctx, "old", "https://example.com"))
+	require.NoError(t, hot.Delete(ctx, []string{"old"}))
+	created := time.Now().Add(-48 * time.Hour)
+	for _, shortURL := range []string{"sharaga", "ab", "old"} {
+		hot.created[shortURL] = created
+	}
+	require.NoError(t, hot.Hit(ctx, "sharaga"))
+	require.NoError(t, hot.Hit(ctx, "sharaga"))
+	store := NewArchivedStorage(hot, archive)
+
+	archived, err := store.Archive(ctx, 24*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 3, archived)
+	require.False(t, hot.Exists(ctx, "sharaga"))
+	require.True(t, hot.Exists(ctx, "fresh"))
+
+	// the archived urls still resolve
+	original, ok := store.Get(ctx, "sharaga")
+	require.True(t, ok)
+	require.Equal(t, "https://mai.ru", original)
+	loaded, ok := store.GetTargets(ctx, "ab")
+	require.True(t, ok)
+	require.Equal(t, targets, loaded)
+	require.True(t, store.IsDeleted(ctx, "old"))
+	require.False(t, store.IsDeleted(ctx, "sharaga"))
+
+	// with their creation time and hits
+	archivedAt, ok := store.CreatedAt(ctx, "sharaga")
+	require.True(t, ok)
+	require.True(t, created.Equal(archivedAt))
+	require.Equal(t, int64(2), store.Hits(ctx, "sharaga"))
+	require.NoError(t, store.Hit(ctx, "sharaga"))
+	require.Equal(t, int64(3), store.Hits(ctx, "sharaga"))
+
+	// and are neither shortened again nor given out
+	shortURL, existed, err := store.GetOrCreate(ctx, "other", "https://mai.ru")
+	require.NoError(t, err)
+	require.True(t, existed)
+	require.Equal(t, "sharaga", shortURL)
+	_, _, err = store.GetOrCreate(ctx, "sharaga", "https://ilovespb.ru")
+	require.ErrorIs(t, err, ErrIDTaken)
+
+	stats, err := store.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.URLs) // the deleted one is not counted
+
+	archived, err = store.Archive(ctx, 24*time.Hour)
+	require.NoError(t, err)
+	require.Zero(t, archived)
+}
+
+// slowArchive lets the other calls run while a url is put
+type slowArchive struct {
+	*MemStorage
+	onPut func()
+}
+
+func (sa *slowArchive) Put(ctx context.Context, record models.URLRecord) error {
+	if sa.onPut != nil {
+		sa.onPut()
+	}
+	return sa.MemStorage.Put(ctx, record)
+}
+
+// A hit and a deletion during the move wait for it and reach the archive
+func Test_ArchiveConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	hot := NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+	hot.created["sharaga"] = time.Now().Add(-48 * time.Hour)
+	archive := &slowArchive{MemStorage: NewMemStorage(nil)}
+	store := NewArchivedStorage(hot, archive)
+
+	done := make(chan struct{})
+	archive.onPut = func() {
+		go func() {
+			defer close(done)
+			assert.NoError(t, store.Hit(ctx, "sharaga"))
+			assert.NoError(t, store.Delete(ctx, []string{"sharaga"}))
+		}()
+		time.Sleep(20 * time.Millisecond) // they would reach the hot storage before the url is dropped
+	}
+	archived, err := store.Archive(ctx, 24*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 1, archived)
+	<-done
+
+	require.False(t, hot.Exists(ctx, "sharaga"))
+	require.True(t, store.IsDeleted(ctx, "sharaga"))
+	require.Equal(t, int64(1), store.Hits(ctx, "sharaga"))
+}
+
+func Test_FileStorageRemove(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	store, err := NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, store.Save(ctx, "sharaga", "https://mai.ru"))
+	require.NoError(t, store.Save(ctx, "test", "https://practicum.net"))
+	require.NoError(t, store.Remove(ctx, []string{"sharaga"}))
+	require.NoError(t, store.Close())
+
+	// the removed url doesn't come back to the hot storage
+	store, err = NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	defer store.Close()
+	require.False(t, store.Exists(ctx, "sharaga"))
+	require.True(t, store.Exists(ctx, "test"))
+}
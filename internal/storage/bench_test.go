@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+// benchStored is the size a store grows to before the benchmark resets it,
+// so the runs with growing b.N measure the same store size
+const benchStored = 1000
+
+func BenchmarkMemStorageSave(b *testing.B) {
+	ctx := context.Background()
+	store := NewMemStorage(nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%benchStored == 0 && i > 0 {
+			b.StopTimer()
+			store = NewMemStorage(nil)
+			b.StartTimer()
+		}
+		id := strconv.Itoa(i % benchStored)
+		if err := store.Save(ctx, id, "https://practicum.net/"+id); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMemStorageGet(b *testing.B) {
+	ctx := context.Background()
+	store := NewMemStorage(nil)
+	for i := 0; i < benchStored; i++ {
+		store.Save(ctx, strconv.Itoa(i), "https://practicum.net/"+strconv.Itoa(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := store.Get(ctx, strconv.Itoa(i%benchStored)); !ok {
+			b.Fatal("not found")
+		}
+	}
+}
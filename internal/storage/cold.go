@@ -0,0 +1,410 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+	"go.uber.org/zap"
+)
+
+// ----------------------ColdStorage----------------------------
+// ColdStorage is the archive kept on the disk: the records are JSON lines of the
+// file, the memory holds only the offsets of the lines and the hit counts. A
+// deletion appends the record again, the latest line of a short url wins. The
+// hits are appended by Flush, so the reads don't grow the file.
+type ColdStorage struct {
+	mu        sync.RWMutex
+	file      *os.File
+	size      int64                // the end of the file, where the next line goes
+	index     map[string]coldEntry // short url -> its latest line
+	originals map[uint64][]string  // hash of the original url -> short urls, compared on the disk
+	hits      map[string]int64     // the urls hit at least once
+	dirty     map[string]bool      // hit since the last flush
+}
+
+// coldEntry is the line of the record in the file
+type coldEntry struct {
+	offset  int64
+	size    int
+	deleted bool // by a deleted line after the record
+}
+
+// NewColdStorage indexes the records of the file, the corrupt lines are logged and skipped
+func NewColdStorage(path string, logger *zap.Logger) (*ColdStorage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	cs := &ColdStorage{
+		file:      file,
+		index:     map[string]coldEntry{},
+		originals: map[uint64][]string{},
+		hits:      map[string]int64{},
+		dirty:     map[string]bool{},
+	}
+	if err = cs.load(path, logger); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return cs, nil
+}
+
+// load indexes the lines of the file, a torn last line is ended so the next one isn't glued to it
+func (cs *ColdStorage) load(path string, logger *zap.Logger) error {
+	counter := &countingReader{reader: cs.file}
+	reader := bufio.NewReader(counter)
+	for line := 1; ; line++ {
+		offset := counter.read - int64(reader.Buffered())
+		data, tooLong, err := readLine(reader, maxLineSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if tooLong {
+			logger.Sugar().Warnw("Archive line skipped", "File", path, "Line", line, "Error", "longer than the limit")
+		} else if len(data) > 0 {
+			if record, ok := parseRecord(path, line, data, logger); ok {
+				cs.indexRecord(record, offset, len(data))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	cs.size = counter.read
+	if cs.size == 0 {
+		return nil
+	}
+	last := make([]byte, 1)
+	if _, err := cs.file.ReadAt(last, cs.size-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		if _, err := cs.file.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+		cs.size++
+	}
+	return nil
+}
+
+// indexRecord points the short url to the line, cs.mu must be held for writing
+func (cs *ColdStorage) indexRecord(record models.URLRecord, offset int64, size int) {
+	entry, known := cs.index[record.ShortURL]
+	switch {
+	case record.Seq > 0: // no url in the record
+	case record.RemovedFlag:
+		delete(cs.index, record.ShortURL)
+		delete(cs.hits, record.ShortURL)
+		delete(cs.dirty, record.ShortURL)
+	case record.DeletedFlag && known: // the record itself is the earlier line
+		entry.deleted = true
+		cs.index[record.ShortURL] = entry
+	default:
+		cs.index[record.ShortURL] = coldEntry{offset: offset, size: size, deleted: record.DeletedFlag}
+		cs.hits[record.ShortURL] = record.Hits
+		if record.Hits == 0 {
+			delete(cs.hits, record.ShortURL)
+		}
+		delete(cs.dirty, record.ShortURL)
+		hash := hashOriginal(record.OriginalURL)
+		if !slices.Contains(cs.originals[hash], record.ShortURL) {
+			cs.originals[hash] = append(cs.originals[hash], record.ShortURL)
+		}
+	}
+}
+
+// write appends the records with a single write and indexes them, cs.mu must be held for writing
+func (cs *ColdStorage) write(records ...models.URLRecord) error {
+	var buff bytes.Buffer
+	encoder := json.NewEncoder(&buff)
+	sizes := make([]int, len(records))
+	for i, record := range records {
+		before := buff.Len()
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+		sizes[i] = buff.Len() - before - 1 // without the newline
+	}
+	if _, err := cs.file.Write(buff.Bytes()); err != nil {
+		return err
+	}
+	for i, record := range records {
+		cs.indexRecord(record, cs.size, sizes[i])
+		cs.size += int64(sizes[i]) + 1
+	}
+	return nil
+}
+
+// read loads the latest record of the short url from the disk, cs.mu must be held
+func (cs *ColdStorage) read(shortURL string) (models.URLRecord, bool, error) {
+	entry, ok := cs.index[shortURL]
+	if !ok {
+		return models.URLRecord{}, false, nil
+	}
+	data := make([]byte, entry.size)
+	if _, err := cs.file.ReadAt(data, entry.offset); err != nil {
+		return models.URLRecord{}, false, err
+	}
+	var record models.URLRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return models.URLRecord{}, false, err
+	}
+	record.DeletedFlag = entry.deleted
+	record.Hits = cs.hits[shortURL]
+	return record, true, nil
+}
+
+// Put appends the record, a deleted one is followed by its deleted line
+func (cs *ColdStorage) Put(ctx context.Context, record models.URLRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	records := []models.URLRecord{record}
+	if record.DeletedFlag {
+		records[0].DeletedFlag = false
+		records = append(records, models.URLRecord{ShortURL: record.ShortURL, OriginalURL: record.OriginalURL, DeletedFlag: true})
+	}
+	return cs.write(records...)
+}
+
+func (cs *ColdStorage) Get(ctx context.Context, shortURL string) (string, bool) {
+	original, err := cs.Lookup(ctx, shortURL)
+	return original, err == nil
+}
+
+func (cs *ColdStorage) Lookup(ctx context.Context, shortURL string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	record, ok, err := cs.read(shortURL)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrNotFound
+	}
+	return record.OriginalURL, nil
+}
+
+func (cs *ColdStorage) Exists(ctx context.Context, shortURL string) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	_, ok := cs.index[shortURL]
+	return ok
+}
+
+// GetByOriginal reads the short urls of the same hash, the deleted ones don't count
+func (cs *ColdStorage) GetByOriginal(ctx context.Context, originalURL string) (string, bool) {
+	if ctx.Err() != nil {
+		return "", false
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	for _, shortURL := range cs.originals[hashOriginal(originalURL)] {
+		record, ok, err := cs.read(shortURL)
+		if err == nil && ok && !record.DeletedFlag && record.OriginalURL == originalURL {
+			return shortURL, true
+		}
+	}
+	return "", false
+}
+
+// Stats counts the urls not deleted
+func (cs *ColdStorage) Stats(ctx context.Context) (models.Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Stats{}, err
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	var stats models.Stats
+	for _, entry := range cs.index {
+		if !entry.deleted {
+			stats.URLs++
+		}
+	}
+	return stats, nil
+}
+
+func (cs *ColdStorage) GetTargets(ctx context.Context, shortURL string) ([]models.Target, bool) {
+	if ctx.Err() != nil {
+		return nil, false
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	record, ok, err := cs.read(shortURL)
+	if err != nil || !ok || len(record.Targets) == 0 {
+		return nil, false
+	}
+	return record.Targets, true
+}
+
+// Delete appends a deleted line for every known short url not deleted yet
+func (cs *ColdStorage) Delete(ctx context.Context, shortURLs []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, shortURL := range shortURLs {
+		record, ok, err := cs.read(shortURL)
+		if err != nil {
+			return err
+		}
+		if !ok || record.DeletedFlag {
+			continue
+		}
+		if err = cs.write(models.URLRecord{ShortURL: shortURL, OriginalURL: record.OriginalURL, DeletedFlag: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cs *ColdStorage) IsDeleted(ctx context.Context, shortURL string) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.index[shortURL].deleted
+}
+
+func (cs *ColdStorage) CreatedAt(ctx context.Context, shortURL string) (time.Time, bool) {
+	if ctx.Err() != nil {
+		return time.Time{}, false
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	record, ok, err := cs.read(shortURL)
+	if err != nil || !ok || record.CreatedAt == nil {
+		return time.Time{}, false
+	}
+	return *record.CreatedAt, true
+}
+
+// Export reads the urls one at a time, like the memory storage
+func (cs *ColdStorage) Export(ctx context.Context, fn func(models.ExportedURL) error) error {
+	cs.mu.RLock()
+	shortURLs := make([]string, 0, len(cs.index))
+	for shortURL := range cs.index {
+		shortURLs = append(shortURLs, shortURL)
+	}
+	cs.mu.RUnlock()
+	sort.Strings(shortURLs)
+
+	for _, shortURL := range shortURLs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cs.mu.RLock()
+		record, ok, err := cs.read(shortURL)
+		cs.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		url := models.ExportedURL{ID: shortURL, OriginalURL: record.OriginalURL, Targets: record.Targets, Deleted: record.DeletedFlag, CreatedAt: record.CreatedAt}
+		if err = fn(url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hit counts in memory, Flush appends the count
+func (cs *ColdStorage) Hit(ctx context.Context, shortURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if entry, ok := cs.index[shortURL]; ok && !entry.deleted {
+		cs.hits[shortURL]++
+		cs.dirty[shortURL] = true
+	}
+	return nil
+}
+
+func (cs *ColdStorage) Hits(ctx context.Context, shortURL string) int64 {
+	if ctx.Err() != nil {
+		return 0
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.hits[shortURL]
+}
+
+// Flush appends the records hit since the last flush with a single write, a
+// url hit many times takes a single line
+func (cs *ColdStorage) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	records := make([]models.URLRecord, 0, len(cs.dirty))
+	for shortURL := range cs.dirty {
+		record, ok, err := cs.read(shortURL)
+		if err != nil {
+			return err
+		}
+		if ok && !record.DeletedFlag { // the deleted urls aren't counted
+			records = append(records, record)
+		}
+	}
+	if err := cs.write(records...); err != nil {
+		return err
+	}
+	clear(cs.dirty)
+	return nil
+}
+
+// Close flushes the hits first
+func (cs *ColdStorage) Close() error {
+	if err := cs.Flush(context.Background()); err != nil {
+		cs.file.Close()
+		return err
+	}
+	return cs.file.Close()
+}
+
+// ----------------------ColdStorage----------------------------
+
+// hashOriginal keys the index of the original urls, which aren't kept in memory
+func hashOriginal(originalURL string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(originalURL))
+	return hash.Sum64()
+}
+
+// countingReader counts the bytes read, the offsets of the lines follow from it
+type countingReader struct {
+	reader io.Reader
+	read   int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.read += int64(n)
+	return n, err
+}
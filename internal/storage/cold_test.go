@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// The archived records, their hits and deletions survive a restart
+func Test_ColdStorageReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "archive.json")
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	targets := []models.Target{{URL: "https://a.example", Weight: 1}, {URL: "https://b.example", Weight: 3}}
+
+	cold, err := NewColdStorage(path, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, cold.Put(ctx, models.URLRecord{ShortURL: "sharaga", OriginalURL: "https://mai.ru", CreatedAt: &created, Hits: 5}))
+	require.NoError(t, cold.Put(ctx, models.URLRecord{ShortURL: "ab", OriginalURL: "https://a.example", Targets: targets, CreatedAt: &created}))
+	require.NoError(t, cold.Put(ctx, models.URLRecord{ShortURL: "old", OriginalURL: "https://example.com", DeletedFlag: true, CreatedAt: &created}))
+	require.NoError(t, cold.Put(ctx, models.URLRecord{ShortURL: "test", OriginalURL: "https://practicum.net", CreatedAt: &created}))
+	require.NoError(t, cold.Hit(ctx, "sharaga"))
+	require.NoError(t, cold.Hit(ctx, "old")) // not counted
+	require.NoError(t, cold.Delete(ctx, []string{"test", "unknown"}))
+	require.NoError(t, cold.Close())
+
+	cold, err = NewColdStorage(path, zap.NewNop())
+	require.NoError(t, err)
+	defer cold.Close()
+
+	original, ok := cold.Get(ctx, "sharaga")
+	require.True(t, ok)
+	require.Equal(t, "https://mai.ru", original)
+	require.Equal(t, int64(6), cold.Hits(ctx, "sharaga"))
+	archivedAt, ok := cold.CreatedAt(ctx, "sharaga")
+	require.True(t, ok)
+	require.True(t, created.Equal(archivedAt))
+	loaded, ok := cold.GetTargets(ctx, "ab")
+	require.True(t, ok)
+	require.Equal(t, targets, loaded)
+	require.Zero(t, cold.Hits(ctx, "old"))
+
+	for _, shortURL := range []string{"old", "test"} {
+		require.True(t, cold.Exists(ctx, shortURL))
+		require.True(t, cold.IsDeleted(ctx, shortURL))
+	}
+	_, err = cold.Lookup(ctx, "unknown")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// the deleted originals can be shortened again
+	shortURL, ok := cold.GetByOriginal(ctx, "https://mai.ru")
+	require.True(t, ok)
+	require.Equal(t, "sharaga", shortURL)
+	_, ok = cold.GetByOriginal(ctx, "https://practicum.net")
+	require.False(t, ok)
+
+	stats, err := cold.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.URLs)
+
+	var exported []models.ExportedURL
+	require.NoError(t, cold.Export(ctx, func(url models.ExportedURL) error {
+		exported = append(exported, url)
+		return nil
+	}))
+	require.Equal(t, []models.ExportedURL{
+		{ID: "ab", OriginalURL: "https://a.example", Targets: targets, CreatedAt: &created},
+		{ID: "old", OriginalURL: "https://example.com", Deleted: true, CreatedAt: &created},
+		{ID: "sharaga", OriginalURL: "https://mai.ru", CreatedAt: &created},
+		{ID: "test", OriginalURL: "https://practicum.net", Deleted: true, CreatedAt: &created},
+	}, exported)
+}
+
+// The hits are counted in memory and a flush appends a line per url
+func Test_ColdStorageHitsFlush(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "archive.json")
+	cold, err := NewColdStorage(path, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, cold.Put(ctx, models.URLRecord{ShortURL: "sharaga", OriginalURL: "https://mai.ru"}))
+	before, err := os.Stat(path)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, cold.Hit(ctx, "sharaga"))
+	}
+	require.Equal(t, int64(100), cold.Hits(ctx, "sharaga"))
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, before.Size(), after.Size())
+
+	require.NoError(t, cold.Flush(ctx))
+	require.NoError(t, cold.Flush(ctx)) // nothing hit since
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, bytes.Count(data, []byte("\n")))
+	require.NoError(t, cold.Close())
+
+	cold, err = NewColdStorage(path, zap.NewNop())
+	require.NoError(t, err)
+	defer cold.Close()
+	require.Equal(t, int64(100), cold.Hits(ctx, "sharaga"))
+}
+
+// A torn last line is skipped and the next record isn't glued to it
+func Test_ColdStorageTornLine(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "archive.json")
+	torn := `{"uuid":"","short_url":"sharaga","original_url":"https://mai.ru"}` + "\n" + `{"uuid":"","short_url":"broken","orig`
+	require.NoError(t, os.WriteFile(path, []byte(torn), 0644))
+
+	cold, err := NewColdStorage(path, zap.NewNop())
+	require.NoError(t, err)
+	require.False(t, cold.Exists(ctx, "broken"))
+	require.NoError(t, cold.Put(ctx, models.URLRecord{ShortURL: "test", OriginalURL: "https://practicum.net"}))
+	require.NoError(t, cold.Close())
+
+	cold, err = NewColdStorage(path, zap.NewNop())
+	require.NoError(t, err)
+	defer cold.Close()
+	for _, shortURL := range []string{"sharaga", "test"} {
+		require.True(t, cold.Exists(ctx, shortURL), shortURL)
+	}
+}
@@ -0,0 +1,376 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+	"go.uber.org/zap"
+)
+
+// ----------------------FileStorage----------------------------
+// FileStorage keeps the urls in memory and appends every new one to a JSON lines file
+type FileStorage struct {
+	*MemStorage
+	mu      sync.Mutex // serializes the writes to the file
+	file    *os.File
+	encoder *json.Encoder
+	lastID  int // uuid of the last record
+	// NextSeq hands out the counter up to seqLimit written to the file, a restart
+	// goes on from the limit, so the rest of the block is skipped, never repeated
+	seq, seqLimit uint64
+}
+
+// NewFileStorage loads the valid records of the file skipping the corrupt ones,
+// they are logged. With quarantine the file having corrupt lines is moved to
+// path.corrupt and only the valid records are written back.
+func NewFileStorage(path string, quarantine bool, logger *zap.Logger) (*FileStorage, error) {
+	records, corrupt, err := loadRecords(path, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if corrupt > 0 && quarantine {
+		if err = quarantineFile(path, records, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStorage{
+		MemStorage: NewMemStorage(nil),
+		file:       file,
+		encoder:    json.NewEncoder(file),
+	}
+	for _, record := range records {
+		switch {
+		case record.Seq > 0:
+		case record.RemovedFlag:
+			fs.MemStorage.Remove(context.Background(), []string{record.ShortURL})
+		case record.DeletedFlag:
+			fs.MemStorage.Delete(context.Background(), []string{record.ShortURL})
+		case len(record.Targets) > 0:
+			fs.MemStorage.mu.Lock() // the older files may save over an id
+			fs.MemStorage.putTargets(record.ShortURL, record.Targets)
+			fs.MemStorage.mu.Unlock()
+		default:
+			fs.MemStorage.Save(context.Background(), record.ShortURL, record.OriginalURL)
+		}
+		if record.CreatedAt != nil && record.Seq == 0 { // the older records have none, they are created at the load
+			fs.MemStorage.restoreCreated(record.ShortURL, *record.CreatedAt)
+		}
+		if record.Seq > 0 {
+			fs.seqLimit = max(fs.seqLimit, record.Seq)
+			fs.seq = fs.seqLimit
+		}
+		if id, err := strconv.Atoi(record.UUID); err == nil && id > fs.lastID {
+			fs.lastID = id
+		}
+	}
+	return fs, nil
+}
+
+func (fs *FileStorage) Save(ctx context.Context, shortURL, originalURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.write(models.URLRecord{ShortURL: shortURL, OriginalURL: originalURL}); err != nil {
+		return err
+	}
+	return fs.MemStorage.Save(ctx, shortURL, originalURL)
+}
+
+func (fs *FileStorage) GetOrCreate(ctx context.Context, shortURL, originalURL string) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, err
+	}
+	fs.mu.Lock() // the check and the write happen under the same lock
+	defer fs.mu.Unlock()
+
+	if existing, ok := fs.MemStorage.GetByOriginal(ctx, originalURL); ok {
+		return existing, true, nil
+	}
+	if fs.MemStorage.Exists(ctx, shortURL) {
+		return "", false, ErrIDTaken
+	}
+	if err := fs.write(models.URLRecord{ShortURL: shortURL, OriginalURL: originalURL}); err != nil {
+		return "", false, err
+	}
+	return shortURL, false, fs.MemStorage.Save(ctx, shortURL, originalURL)
+}
+
+// SaveBatch appends the whole batch with a single write
+func (fs *FileStorage) SaveBatch(ctx context.Context, records []models.URLRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.MemStorage.mu.RLock()
+	err := fs.MemStorage.checkBatch(records)
+	fs.MemStorage.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	var buff bytes.Buffer
+	encoder := json.NewEncoder(&buff)
+	lastID := fs.lastID
+	now := fs.MemStorage.now()
+	for _, record := range records {
+		lastID++
+		record.UUID = strconv.Itoa(lastID)
+		record.CreatedAt = &now
+		if err = encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	if _, err = fs.file.Write(buff.Bytes()); err != nil {
+		return err
+	}
+	fs.lastID = lastID
+	return fs.MemStorage.SaveBatch(ctx, records)
+}
+
+func (fs *FileStorage) SaveTargets(ctx context.Context, shortURL string, targets []models.Target) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return errNoTargets
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.MemStorage.Exists(ctx, shortURL) {
+		return ErrIDTaken
+	}
+	record := models.URLRecord{ShortURL: shortURL, OriginalURL: targets[0].URL, Targets: targets}
+	if err := fs.write(record); err != nil {
+		return err
+	}
+	return fs.MemStorage.SaveTargets(ctx, shortURL, targets)
+}
+
+// Import appends the record with its creation time, then a deleted one for the deleted url
+func (fs *FileStorage) Import(ctx context.Context, url models.ExportedURL) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if len(url.Targets) > 0 && fs.MemStorage.Exists(ctx, url.ID) {
+		return ErrIDTaken
+	}
+	record := importedRecord(url)
+	record.DeletedFlag = false
+	if err := fs.write(record); err != nil {
+		return err
+	}
+	if url.Deleted {
+		if err := fs.write(models.URLRecord{ShortURL: url.ID, OriginalURL: record.OriginalURL, DeletedFlag: true}); err != nil {
+			return err
+		}
+	}
+	return fs.MemStorage.Import(ctx, url)
+}
+
+// Delete appends a deleted record for every known short url not deleted yet
+func (fs *FileStorage) Delete(ctx context.Context, shortURLs []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, shortURL := range shortURLs {
+		original, ok := fs.MemStorage.Get(ctx, shortURL)
+		if !ok || fs.MemStorage.IsDeleted(ctx, shortURL) {
+			continue
+		}
+		record := models.URLRecord{ShortURL: shortURL, OriginalURL: original, DeletedFlag: true}
+		if err := fs.write(record); err != nil {
+			return err
+		}
+	}
+	return fs.MemStorage.Delete(ctx, shortURLs)
+}
+
+// Remove appends a removed record for every known short url
+func (fs *FileStorage) Remove(ctx context.Context, shortURLs []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, shortURL := range shortURLs {
+		original, ok := fs.MemStorage.Get(ctx, shortURL)
+		if !ok {
+			continue
+		}
+		record := models.URLRecord{ShortURL: shortURL, OriginalURL: original, RemovedFlag: true}
+		if err := fs.write(record); err != nil {
+			return err
+		}
+	}
+	return fs.MemStorage.Remove(ctx, shortURLs)
+}
+
+// MoveBefore appends a removed record for every url moved
+func (fs *FileStorage) MoveBefore(ctx context.Context, t time.Time, put func(models.URLRecord) error) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.MemStorage.mu.Lock()
+	defer fs.MemStorage.mu.Unlock()
+
+	return fs.MemStorage.moveBefore(t, put, func(record models.URLRecord) error {
+		return fs.write(models.URLRecord{ShortURL: record.ShortURL, OriginalURL: record.OriginalURL, RemovedFlag: true})
+	})
+}
+
+// seqBlock is the counter reserved by a single write
+const seqBlock = 100
+
+func (fs *FileStorage) NextSeq(ctx context.Context) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.seq == fs.seqLimit {
+		if err := fs.write(models.URLRecord{Seq: fs.seqLimit + seqBlock}); err != nil {
+			return 0, err
+		}
+		fs.seqLimit += seqBlock
+	}
+	fs.seq++
+	return fs.seq, nil
+}
+
+// write appends the record with the next uuid and the creation time of a
+// saved one unless it has one, fs.mu must be held
+func (fs *FileStorage) write(record models.URLRecord) error {
+	fs.lastID++
+	record.UUID = strconv.Itoa(fs.lastID)
+	if !record.DeletedFlag && !record.RemovedFlag && record.Seq == 0 && record.CreatedAt == nil {
+		now := fs.MemStorage.now()
+		record.CreatedAt = &now
+	}
+	return fs.encoder.Encode(record)
+}
+
+func (fs *FileStorage) Close() error {
+	return fs.file.Close()
+}
+
+// ----------------------FileStorage----------------------------
+
+// maxLineSize is the longest record line loaded, a longer one is corrupt
+const maxLineSize = 16 << 20
+
+// loadRecords reads the file line by line, a missing file is an empty storage
+func loadRecords(path string, logger *zap.Logger) (records []models.URLRecord, corrupt int, err error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, tooLong, err := readLine(reader, maxLineSize)
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		if tooLong {
+			logger.Sugar().Warnw("File storage line skipped", "File", path, "Line", line, "Error", "longer than the limit")
+			corrupt++
+		} else if len(data) > 0 {
+			if record, ok := parseRecord(path, line, data, logger); ok {
+				records = append(records, record)
+			} else {
+				corrupt++
+			}
+		}
+		if err == io.EOF {
+			return records, corrupt, nil
+		}
+	}
+}
+
+// parseRecord decodes a complete record of the line, the others are logged
+func parseRecord(path string, line int, data []byte, logger *zap.Logger) (models.URLRecord, bool) {
+	var record models.URLRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		logger.Sugar().Warnw("File storage line skipped", "File", path, "Line", line, "Error", err)
+		return record, false
+	}
+	if record.Seq == 0 && (record.ShortURL == "" || record.OriginalURL == "") {
+		logger.Sugar().Warnw("File storage line skipped", "File", path, "Line", line, "Error", "incomplete record")
+		return record, false
+	}
+	return record, true
+}
+
+// readLine reads up to the newline, the line over the limit is discarded
+// to the end and reported as too long
+func readLine(reader *bufio.Reader, limit int) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong && len(line)+len(chunk) > limit {
+			tooLong, line = true, nil
+		}
+		if !tooLong {
+			line = append(line, chunk...)
+		}
+		if err != bufio.ErrBufferFull {
+			return bytes.TrimRight(line, "\r\n"), tooLong, err
+		}
+	}
+}
+
+// quarantineFile keeps the corrupt file aside and rewrites the valid records
+func quarantineFile(path string, records []models.URLRecord, logger *zap.Logger) error {
+	quarantined := path + ".corrupt"
+	if err := os.Rename(path, quarantined); err != nil {
+		return err
+	}
+	logger.Sugar().Warnw("Corrupt file storage quarantined", "File", path, "Moved to", quarantined)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	for _, record := range records {
+		if err = encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
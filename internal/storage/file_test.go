@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const corruptFile = `{"uuid":"1","short_url":"sharaga","original_url":"https://mai.ru"}
+{"uuid":"2","short_url":"broken","original_url":
+not a json at all
+{"uuid":"3","short_url":"","original_url":"https://empty.id"}
+
+{"uuid":"4","short_url":"api","original_url":"https://practicum.net"}
+`
+
+func Test_FileStorageCorruptLines(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		Name           string
+		Quarantine     bool
+		WantQuarantine bool
+	}{
+		{
+			Name:           "Skip corrupt lines",
+			Quarantine:     false,
+			WantQuarantine: false,
+		},
+		{
+			Name:           "Quarantine corrupt file",
+			Quarantine:     true,
+			WantQuarantine: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "urls.json")
+			require.NoError(t, os.WriteFile(path, []byte(corruptFile), 0644))
+
+			core, logs := observer.New(zap.WarnLevel)
+			store, err := NewFileStorage(path, tc.Quarantine, zap.New(core))
+			require.NoError(t, err)
+			defer store.Close()
+			require.Equal(t, 3, logs.FilterMessage("File storage line skipped").Len())
+
+			// the valid entries are loaded
+			original, ok := store.Get(ctx, "sharaga")
+			require.True(t, ok)
+			require.Equal(t, "https://mai.ru", original)
+			original, ok = store.Get(ctx, "api")
+			require.True(t, ok)
+			require.Equal(t, "https://practicum.net", original)
+			require.False(t, store.Exists(ctx, "broken"))
+			require.False(t, store.Exists(ctx, ""))
+
+			_, err = os.Stat(path + ".corrupt")
+			require.Equal(t, tc.WantQuarantine, err == nil)
+			if tc.WantQuarantine {
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				require.Equal(t, 2, strings.Count(string(data), "\n"))
+			}
+		})
+	}
+}
+
+// A record longer than the scanner buffer is loaded, the one over the limit is corrupt
+func Test_FileStorageLongLines(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	store, err := NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	var targets []models.Target
+	for i := 0; i < 2000; i++ { // about 100 KB
+		targets = append(targets, models.Target{URL: "https://site.example/" + strings.Repeat("a", 30) + strconv.Itoa(i), Weight: 1})
+	}
+	require.NoError(t, store.SaveTargets(ctx, "many", targets))
+	require.NoError(t, store.Save(ctx, "sharaga", "https://mai.ru"))
+	require.NoError(t, store.Close())
+
+	store, err = NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	defer store.Close()
+	loaded, ok := store.GetTargets(ctx, "many")
+	require.True(t, ok)
+	require.Equal(t, targets, loaded)
+	require.True(t, store.Exists(ctx, "sharaga"))
+}
+
+func Test_ReadLine(t *testing.T) {
+	reader := bufio.NewReaderSize(strings.NewReader("short\n"+strings.Repeat("x", 100)+"\nlast"), 16)
+	var lines []string
+	var tooLong []bool
+	for {
+		line, long, err := readLine(reader, 32)
+		lines, tooLong = append(lines, string(line)), append(tooLong, long)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"short", "", "last"}, lines)
+	require.Equal(t, []bool{false, true, false}, tooLong)
+}
+
+func Test_FileStorageReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	store, err := NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, store.Save(ctx, "sharaga", "https://mai.ru"))
+	targets := []models.Target{{URL: "https://a.example", Weight: 1}, {URL: "https://b.example", Weight: 3}}
+	require.NoError(t, store.SaveTargets(ctx, "ab", targets))
+	_, existed, err := store.GetOrCreate(ctx, "test", "https://practicum.net")
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.NoError(t, store.Save(ctx, "old", "https://example.com"))
+	require.NoError(t, store.SaveBatch(ctx, []models.URLRecord{{ShortURL: "batch", OriginalURL: "https://batch.example"}}))
+	require.Error(t, store.SaveBatch(ctx, []models.URLRecord{{ShortURL: "failed", OriginalURL: "https://failed.example"}, {ShortURL: "batch", OriginalURL: "https://other.example"}}))
+	require.NoError(t, store.Delete(ctx, []string{"old"}))
+	require.NoError(t, store.Close())
+
+	store, err = NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	defer store.Close()
+	original, ok := store.Get(ctx, "sharaga")
+	require.True(t, ok)
+	require.Equal(t, "https://mai.ru", original)
+	shortURL, ok := store.GetByOriginal(ctx, "https://mai.ru")
+	require.True(t, ok)
+	require.Equal(t, "sharaga", shortURL)
+	shortURL, existed, err = store.GetOrCreate(ctx, "other", "https://practicum.net")
+	require.NoError(t, err)
+	require.True(t, existed)
+	require.Equal(t, "test", shortURL)
+	require.True(t, store.IsDeleted(ctx, "old"))
+	require.True(t, store.Exists(ctx, "batch"))
+	require.False(t, store.Exists(ctx, "failed"))
+	require.False(t, store.IsDeleted(ctx, "sharaga"))
+	loaded, ok := store.GetTargets(ctx, "ab")
+	require.True(t, ok)
+	require.Equal(t, targets, loaded)
+}
+
+func Test_FileStorageCreatedAt(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	store, err := NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	store.now = func() time.Time { return first }
+	require.NoError(t, store.Save(ctx, "sharaga", "https://mai.ru"))
+	require.NoError(t, store.SaveBatch(ctx, []models.URLRecord{{ShortURL: "batch", OriginalURL: "https://batch.example"}}))
+	store.now = func() time.Time { return second }
+	_, _, err = store.GetOrCreate(ctx, "test", "https://practicum.net")
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(ctx, []string{"sharaga"})) // keeps the time
+	require.NoError(t, store.Close())
+
+	store, err = NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	defer store.Close()
+	for shortURL, want := range map[string]time.Time{"sharaga": first, "batch": first, "test": second} {
+		created, ok := store.CreatedAt(ctx, shortURL)
+		require.True(t, ok, shortURL)
+		require.True(t, want.Equal(created), "%s created at %s, want %s", shortURL, created, want)
+	}
+	_, ok := store.CreatedAt(ctx, "unknown")
+	require.False(t, ok)
+}
+
+// The counter goes on after a restart, the rest of the reserved block is skipped
+func Test_FileStorageNextSeq(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	fs, err := NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, fs.Save(ctx, "sharaga", "https://mai.ru"))
+	var last uint64
+	for i := 0; i < seqBlock+1; i++ { // into the second block
+		seq, err := fs.NextSeq(ctx)
+		require.NoError(t, err)
+		require.Greater(t, seq, last)
+		last = seq
+	}
+	require.NoError(t, fs.Close())
+
+	fs, err = NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	defer fs.Close()
+	seq, err := fs.NextSeq(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2*seqBlock+1), seq)
+	original, ok := fs.Get(ctx, "sharaga") // the counter records aren't urls
+	require.True(t, ok)
+	require.Equal(t, "https://mai.ru", original)
+	stats, err := fs.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.URLs)
+}
+
+// The imported urls keep their creation time and deletion after a restart
+func Test_FileStorageImport(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	targets := []models.Target{{URL: "https://a.example", Weight: 1}, {URL: "https://b.example", Weight: 3}}
+	urls := []models.ExportedURL{
+		{ID: "ab", OriginalURL: "https://a.example", Targets: targets, CreatedAt: &created},
+		{ID: "old", OriginalURL: "https://practicum.net", Deleted: true, CreatedAt: &created},
+		{ID: "sharaga", OriginalURL: "https://mai.ru", CreatedAt: &created},
+	}
+
+	store, err := NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	for _, url := range urls {
+		require.NoError(t, store.Import(ctx, url))
+	}
+	require.ErrorIs(t, store.Import(ctx, models.ExportedURL{ID: "sharaga", OriginalURL: "https://a.example", Targets: targets}), ErrIDTaken)
+	require.NoError(t, store.Close())
+
+	store, err = NewFileStorage(path, false, zap.NewNop())
+	require.NoError(t, err)
+	defer store.Close()
+	var exported []models.ExportedURL
+	require.NoError(t, store.Export(ctx, func(url models.ExportedURL) error {
+		exported = append(exported, url)
+		return nil
+	}))
+	require.Len(t, exported, len(urls))
+	for i, url := range exported {
+		require.True(t, created.Equal(*url.CreatedAt), url.ID)
+		url.CreatedAt = &created
+		require.Equal(t, urls[i], url)
+	}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+)
+
+var (
+	// ErrKeyInProgress is returned by Begin while the first request with the key is handled
+	ErrKeyInProgress = errors.New("a request with the idempotency key is in progress")
+	// ErrKeyReused is returned by Begin when the key comes with another request
+	ErrKeyReused = errors.New("the idempotency key is used by another request")
+)
+
+type idempotencyEntry struct {
+	fingerprint string
+	response    *models.CachedResponse // nil while the request is handled
+	expires     time.Time
+}
+
+// ----------------------IdempotencyCache----------------------------
+// IdempotencyCache keeps the answers by the Idempotency-Key for the ttl,
+// in memory only
+type IdempotencyCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]*idempotencyEntry
+	nextSweep time.Time
+	now       func() time.Time // the clock, replaced in tests
+}
+
+func NewIdempotencyCache(ttl time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{ttl: ttl, entries: map[string]*idempotencyEntry{}, now: time.Now}
+}
+
+// Begin claims the key for the request identified by the fingerprint. The
+// answer of the finished first request is returned for the replay, nil means
+// the caller handles the request and must Finish or Abort it.
+func (ic *IdempotencyCache) Begin(ctx context.Context, key, fingerprint string) (*models.CachedResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	now := ic.now()
+	ic.sweep(now)
+	if entry, ok := ic.entries[key]; ok && now.Before(entry.expires) {
+		switch {
+		case entry.fingerprint != fingerprint:
+			return nil, ErrKeyReused
+		case entry.response == nil:
+			return nil, ErrKeyInProgress
+		}
+		return entry.response, nil
+	}
+	ic.entries[key] = &idempotencyEntry{fingerprint: fingerprint, expires: now.Add(ic.ttl)}
+	return nil, nil
+}
+
+// Finish keeps the answer of the claimed key until the ttl since Begin
+func (ic *IdempotencyCache) Finish(key string, response models.CachedResponse) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if entry, ok := ic.entries[key]; ok && entry.response == nil {
+		entry.response = &response
+	}
+}
+
+// Abort releases the claimed key, so the request can be retried
+func (ic *IdempotencyCache) Abort(key string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if entry, ok := ic.entries[key]; ok && entry.response == nil {
+		delete(ic.entries, key)
+	}
+}
+
+// sweep drops the expired entries at most once a ttl, ic.mu must be held
+func (ic *IdempotencyCache) sweep(now time.Time) {
+	if now.Before(ic.nextSweep) {
+		return
+	}
+	for key, entry := range ic.entries {
+		if !now.Before(entry.expires) {
+			delete(ic.entries, key)
+		}
+	}
+	ic.nextSweep = now.Add(ic.ttl)
+}
+
+// ----------------------IdempotencyCache----------------------------
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_IdempotencyCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewIdempotencyCache(time.Hour)
+	cache.now = func() time.Time { return now }
+	answer := models.CachedResponse{Status: 201, Header: map[string]string{"X-Short-Id": "first"}, Body: []byte("/first")}
+
+	cached, err := cache.Begin(ctx, "key", "request")
+	require.NoError(t, err)
+	require.Nil(t, cached)
+
+	_, err = cache.Begin(ctx, "key", "request")
+	require.ErrorIs(t, err, ErrKeyInProgress)
+	_, err = cache.Begin(ctx, "key", "other request")
+	require.ErrorIs(t, err, ErrKeyReused)
+
+	cache.Finish("key", answer)
+	cached, err = cache.Begin(ctx, "key", "request")
+	require.NoError(t, err)
+	require.Equal(t, &answer, cached)
+	cache.Abort("key") // the finished keys stay
+	cached, err = cache.Begin(ctx, "key", "request")
+	require.NoError(t, err)
+	require.Equal(t, &answer, cached)
+
+	// expired, the key is claimed again
+	now = now.Add(time.Hour)
+	cached, err = cache.Begin(ctx, "key", "other request")
+	require.NoError(t, err)
+	require.Nil(t, cached)
+
+	// aborted, the retry handles the request
+	cache.Abort("key")
+	cached, err = cache.Begin(ctx, "key", "request")
+	require.NoError(t, err)
+	require.Nil(t, cached)
+}
+
+func Test_IdempotencyCacheSweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewIdempotencyCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	for _, key := range []string{"first", "second"} {
+		_, err := cache.Begin(ctx, key, "request")
+		require.NoError(t, err)
+		cache.Finish(key, models.CachedResponse{Status: 201})
+	}
+	now = now.Add(time.Minute)
+	_, err := cache.Begin(ctx, "third", "request")
+	require.NoError(t, err)
+	require.Len(t, cache.entries, 1)
+	require.Contains(t, cache.entries, "third")
+}
@@ -0,0 +1,353 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+	_ "github.com/jackc/pgx/v5/stdlib" // the pgx database/sql driver
+)
+
+// migrations create the schema, every statement can be run again.
+// The original urls are unique among the live plain short urls only:
+// a deleted one can be shortened again and a weighted one keeps its first target.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS short_urls (
+		short_url    TEXT PRIMARY KEY,
+		original_url TEXT NOT NULL,
+		targets      JSONB,
+		is_deleted   BOOLEAN NOT NULL DEFAULT FALSE
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS short_urls_original_url_idx
+		ON short_urls (original_url) WHERE NOT is_deleted AND targets IS NULL`,
+	`ALTER TABLE short_urls ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+	`ALTER TABLE short_urls ADD COLUMN IF NOT EXISTS hits BIGINT NOT NULL DEFAULT 0`,
+	`CREATE SEQUENCE IF NOT EXISTS short_url_seq`,
+}
+
+// PoolConfig tunes the connection pool of the database, 0 keeps the driver default
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// poolSetter is the pool part of *sql.DB
+type poolSetter interface {
+	SetMaxOpenConns(n int)
+	SetMaxIdleConns(n int)
+	SetConnMaxLifetime(d time.Duration)
+}
+
+func (pc PoolConfig) apply(db poolSetter) {
+	if pc.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(pc.MaxOpenConns)
+	}
+	if pc.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(pc.MaxIdleConns)
+	}
+	if pc.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(pc.ConnMaxLifetime)
+	}
+}
+
+// ----------------------PostgresStorage----------------------------
+// PostgresStorage keeps the urls in the short_urls table
+type PostgresStorage struct {
+	db *sql.DB
+}
+
+// NewPostgresStorage connects to the database with the pool config and migrates the schema
+func NewPostgresStorage(ctx context.Context, dsn string, pool PoolConfig) (*PostgresStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	pool.apply(db)
+	ps := &PostgresStorage{db: db}
+	if err = db.PingContext(ctx); err == nil {
+		err = ps.Migrate(ctx)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+// Migrate creates the table and the indexes unless they exist
+func (ps *PostgresStorage) Migrate(ctx context.Context) error {
+	for _, migration := range migrations {
+		if _, err := ps.db.ExecContext(ctx, migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) Save(ctx context.Context, shortURL, originalURL string) error {
+	_, err := ps.db.ExecContext(ctx, `
+		INSERT INTO short_urls (short_url, original_url) VALUES ($1, $2)
+		ON CONFLICT (short_url) DO UPDATE
+		SET original_url = EXCLUDED.original_url, targets = NULL, is_deleted = FALSE, created_at = now()`,
+		shortURL, originalURL)
+	return err
+}
+
+func (ps *PostgresStorage) Get(ctx context.Context, shortURL string) (string, bool) {
+	var original string
+	err := ps.db.QueryRowContext(ctx,
+		`SELECT original_url FROM short_urls WHERE short_url = $1`, shortURL).Scan(&original)
+	return original, err == nil
+}
+
+func (ps *PostgresStorage) Lookup(ctx context.Context, shortURL string) (string, error) {
+	var original string
+	err := ps.db.QueryRowContext(ctx,
+		`SELECT original_url FROM short_urls WHERE short_url = $1`, shortURL).Scan(&original)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return original, err
+}
+
+func (ps *PostgresStorage) Exists(ctx context.Context, shortURL string) bool {
+	_, ok := ps.Get(ctx, shortURL)
+	return ok
+}
+
+func (ps *PostgresStorage) GetByOriginal(ctx context.Context, originalURL string) (string, bool) {
+	var shortURL string
+	err := ps.db.QueryRowContext(ctx, `
+		SELECT short_url FROM short_urls
+		WHERE original_url = $1 AND NOT is_deleted AND targets IS NULL`, originalURL).Scan(&shortURL)
+	return shortURL, err == nil
+}
+
+// GetOrCreate relies on the unique indexes, the insert of a taken id or a
+// known original does nothing
+func (ps *PostgresStorage) GetOrCreate(ctx context.Context, shortURL, originalURL string) (string, bool, error) {
+	var id string
+	err := ps.db.QueryRowContext(ctx, `
+		INSERT INTO short_urls (short_url, original_url) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+		RETURNING short_url`, shortURL, originalURL).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", false, err
+	}
+	if existing, ok := ps.GetByOriginal(ctx, originalURL); ok {
+		return existing, true, nil
+	}
+	if err = ctx.Err(); err != nil {
+		return "", false, err
+	}
+	return "", false, ErrIDTaken
+}
+
+// SaveBatch inserts the batch in a single transaction, any failed row rolls it back
+func (ps *PostgresStorage) SaveBatch(ctx context.Context, records []models.URLRecord) error {
+	tx, err := ps.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // does nothing after Commit
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO short_urls (short_url, original_url) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, record := range records {
+		result, err := stmt.ExecContext(ctx, record.ShortURL, record.OriginalURL)
+		if err != nil {
+			return err
+		}
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if inserted == 0 {
+			return batchConflict(ctx, tx, record.ShortURL)
+		}
+	}
+	return tx.Commit()
+}
+
+// batchConflict tells the taken short url of the row not inserted from the shortened original url
+func batchConflict(ctx context.Context, tx *sql.Tx, shortURL string) error {
+	var taken bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM short_urls WHERE short_url = $1)`, shortURL).Scan(&taken)
+	switch {
+	case err != nil:
+		return err
+	case taken:
+		return ErrIDTaken
+	}
+	return ErrOriginalTaken
+}
+
+// Stats counts the urls not deleted
+func (ps *PostgresStorage) Stats(ctx context.Context) (models.Stats, error) {
+	var stats models.Stats
+	err := ps.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM short_urls WHERE NOT is_deleted`).Scan(&stats.URLs)
+	return stats, err
+}
+
+func (ps *PostgresStorage) SaveTargets(ctx context.Context, shortURL string, targets []models.Target) error {
+	if len(targets) == 0 {
+		return errNoTargets
+	}
+	data, err := json.Marshal(targets)
+	if err != nil {
+		return err
+	}
+	result, err := ps.db.ExecContext(ctx, `
+		INSERT INTO short_urls (short_url, original_url, targets) VALUES ($1, $2, $3)
+		ON CONFLICT (short_url) DO NOTHING`,
+		shortURL, targets[0].URL, data)
+	if err != nil {
+		return err
+	}
+	inserted, err := result.RowsAffected()
+	if err == nil && inserted == 0 {
+		err = ErrIDTaken
+	}
+	return err
+}
+
+// Export streams the rows, they aren't loaded all at once
+func (ps *PostgresStorage) Export(ctx context.Context, fn func(models.ExportedURL) error) error {
+	rows, err := ps.db.QueryContext(ctx,
+		`SELECT short_url, original_url, targets, is_deleted, created_at FROM short_urls ORDER BY short_url`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var url models.ExportedURL
+		var data []byte
+		var created time.Time
+		if err = rows.Scan(&url.ID, &url.OriginalURL, &data, &url.Deleted, &created); err != nil {
+			return err
+		}
+		url.CreatedAt = &created
+		if data != nil {
+			if err = json.Unmarshal(data, &url.Targets); err != nil {
+				return err
+			}
+		}
+		if err = fn(url); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Import upserts a plain url, the weighted one is inserted unless the id is taken
+func (ps *PostgresStorage) Import(ctx context.Context, url models.ExportedURL) error {
+	if len(url.Targets) == 0 {
+		_, err := ps.db.ExecContext(ctx, `
+			INSERT INTO short_urls (short_url, original_url, is_deleted, created_at)
+			VALUES ($1, $2, $3, COALESCE($4::timestamptz, now()))
+			ON CONFLICT (short_url) DO UPDATE
+			SET original_url = EXCLUDED.original_url, targets = NULL,
+				is_deleted = EXCLUDED.is_deleted, created_at = EXCLUDED.created_at`,
+			url.ID, url.OriginalURL, url.Deleted, url.CreatedAt)
+		return err
+	}
+	data, err := json.Marshal(url.Targets)
+	if err != nil {
+		return err
+	}
+	result, err := ps.db.ExecContext(ctx, `
+		INSERT INTO short_urls (short_url, original_url, targets, is_deleted, created_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5::timestamptz, now()))
+		ON CONFLICT (short_url) DO NOTHING`,
+		url.ID, url.Targets[0].URL, data, url.Deleted, url.CreatedAt)
+	if err != nil {
+		return err
+	}
+	inserted, err := result.RowsAffected()
+	if err == nil && inserted == 0 {
+		err = ErrIDTaken
+	}
+	return err
+}
+
+func (ps *PostgresStorage) GetTargets(ctx context.Context, shortURL string) ([]models.Target, bool) {
+	var data []byte
+	err := ps.db.QueryRowContext(ctx, `
+		SELECT targets FROM short_urls
+		WHERE short_url = $1 AND targets IS NOT NULL`, shortURL).Scan(&data)
+	if err != nil {
+		return nil, false
+	}
+	var targets []models.Target
+	if err = json.Unmarshal(data, &targets); err != nil {
+		return nil, false
+	}
+	return targets, true
+}
+
+func (ps *PostgresStorage) Delete(ctx context.Context, shortURLs []string) error {
+	_, err := ps.db.ExecContext(ctx,
+		`UPDATE short_urls SET is_deleted = TRUE WHERE short_url = ANY($1)`, shortURLs)
+	return err
+}
+
+func (ps *PostgresStorage) IsDeleted(ctx context.Context, shortURL string) bool {
+	var deleted bool
+	err := ps.db.QueryRowContext(ctx,
+		`SELECT is_deleted FROM short_urls WHERE short_url = $1`, shortURL).Scan(&deleted)
+	return err == nil && deleted
+}
+
+func (ps *PostgresStorage) CreatedAt(ctx context.Context, shortURL string) (time.Time, bool) {
+	var created time.Time
+	err := ps.db.QueryRowContext(ctx,
+		`SELECT created_at FROM short_urls WHERE short_url = $1`, shortURL).Scan(&created)
+	return created, err == nil
+}
+
+// Hit increments the counter in the row, concurrent hits don't get lost
+func (ps *PostgresStorage) Hit(ctx context.Context, shortURL string) error {
+	_, err := ps.db.ExecContext(ctx,
+		`UPDATE short_urls SET hits = hits + 1 WHERE short_url = $1`, shortURL)
+	return err
+}
+
+func (ps *PostgresStorage) Hits(ctx context.Context, shortURL string) int64 {
+	var hits int64
+	err := ps.db.QueryRowContext(ctx,
+		`SELECT hits FROM short_urls WHERE short_url = $1`, shortURL).Scan(&hits)
+	if err != nil {
+		return 0
+	}
+	return hits
+}
+
+func (ps *PostgresStorage) NextSeq(ctx context.Context) (uint64, error) {
+	var seq int64
+	err := ps.db.QueryRowContext(ctx, `SELECT nextval('short_url_seq')`).Scan(&seq)
+	return uint64(seq), err
+}
+
+// Ping checks the database is reachable
+func (ps *PostgresStorage) Ping(ctx context.Context) error {
+	return ps.db.PingContext(ctx)
+}
+
+func (ps *PostgresStorage) Close() error {
+	return ps.db.Close()
+}
+
+// ----------------------PostgresStorage----------------------------
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+// testPostgres connects to TEST_DATABASE_DSN or skips the test
+func testPostgres(t *testing.T) *PostgresStorage {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	store, err := NewPostgresStorage(context.Background(), dsn, PoolConfig{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		store.db.Exec(`DROP TABLE IF EXISTS short_urls`)
+		store.Close()
+	})
+	return store
+}
+
+func Test_PostgresMigrate(t *testing.T) {
+	store := testPostgres(t) // the first run
+
+	require.NoError(t, store.Migrate(context.Background()))
+	require.NoError(t, store.Migrate(context.Background()))
+}
+
+func Test_PostgresStorage(t *testing.T) {
+	ctx := context.Background()
+	store := testPostgres(t)
+
+	require.NoError(t, store.Save(ctx, "sharaga", "https://mai.ru"))
+	original, ok := store.Get(ctx, "sharaga")
+	require.True(t, ok)
+	require.Equal(t, "https://mai.ru", original)
+	created, ok := store.CreatedAt(ctx, "sharaga")
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now(), created, time.Minute)
+
+	shortURL, existed, err := store.GetOrCreate(ctx, "other", "https://mai.ru")
+	require.NoError(t, err)
+	require.True(t, existed)
+	require.Equal(t, "sharaga", shortURL)
+	_, _, err = store.GetOrCreate(ctx, "sharaga", "https://practicum.net")
+	require.ErrorIs(t, err, ErrIDTaken)
+
+	require.NoError(t, store.Delete(ctx, []string{"sharaga"}))
+	require.True(t, store.IsDeleted(ctx, "sharaga"))
+	_, ok = store.GetByOriginal(ctx, "https://mai.ru")
+	require.False(t, ok)
+}
+
+func Test_PostgresSaveBatchRollback(t *testing.T) {
+	ctx := context.Background()
+	store := testPostgres(t)
+	require.NoError(t, store.Save(ctx, "sharaga", "https://mai.ru"))
+
+	// the second row breaks the primary key, the first one must not persist
+	err := store.SaveBatch(ctx, []models.URLRecord{
+		{ShortURL: "first", OriginalURL: "https://practicum.net"},
+		{ShortURL: "sharaga", OriginalURL: "https://ilovespb.ru"},
+		{ShortURL: "third", OriginalURL: "https://example.com"},
+	})
+	require.ErrorIs(t, err, ErrIDTaken)
+	require.False(t, store.Exists(ctx, "first"))
+	require.False(t, store.Exists(ctx, "third"))
+	stats, err := store.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.URLs)
+
+	require.NoError(t, store.SaveBatch(ctx, []models.URLRecord{
+		{ShortURL: "first", OriginalURL: "https://practicum.net"},
+		{ShortURL: "third", OriginalURL: "https://example.com"},
+	}))
+	require.True(t, store.Exists(ctx, "first"))
+
+	err = store.SaveBatch(ctx, []models.URLRecord{{ShortURL: "other", OriginalURL: "https://mai.ru"}})
+	require.ErrorIs(t, err, ErrOriginalTaken)
+}
+
+// recordingPool keeps the pool settings applied to it
+type recordingPool struct {
+	calls []string
+	PoolConfig
+}
+
+func (rp *recordingPool) SetMaxOpenConns(n int) {
+	rp.calls = append(rp.calls, "SetMaxOpenConns")
+	rp.MaxOpenConns = n
+}
+
+func (rp *recordingPool) SetMaxIdleConns(n int) {
+	rp.calls = append(rp.calls, "SetMaxIdleConns")
+	rp.MaxIdleConns = n
+}
+
+func (rp *recordingPool) SetConnMaxLifetime(d time.Duration) {
+	rp.calls = append(rp.calls, "SetConnMaxLifetime")
+	rp.ConnMaxLifetime = d
+}
+
+func Test_PoolConfigApply(t *testing.T) {
+	pool := PoolConfig{MaxOpenConns: 20, MaxIdleConns: 5, ConnMaxLifetime: 30 * time.Minute}
+	rp := &recordingPool{}
+	pool.apply(rp)
+	require.Equal(t, []string{"SetMaxOpenConns", "SetMaxIdleConns", "SetConnMaxLifetime"}, rp.calls)
+	require.Equal(t, pool, rp.PoolConfig)
+
+	// the zero values keep the driver defaults
+	rp = &recordingPool{}
+	PoolConfig{MaxIdleConns: 2}.apply(rp)
+	require.Equal(t, []string{"SetMaxIdleConns"}, rp.calls)
+}
+
+func Test_PostgresPool(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	store, err := NewPostgresStorage(context.Background(), dsn, PoolConfig{MaxOpenConns: 3})
+	require.NoError(t, err)
+	defer store.Close()
+	require.Equal(t, 3, store.db.Stats().MaxOpenConnections)
+}
@@ -0,0 +1,568 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+	"go.uber.org/zap"
+)
+
+var errNoTargets = errors.New("no targets for the weighted url")
+
+// ErrIDTaken is returned by GetOrCreate when the short url keeps another original url
+var ErrIDTaken = errors.New("short url is taken")
+
+// ErrNotFound is returned by Lookup for an unknown short url
+var ErrNotFound = errors.New("short url not found")
+
+// ErrOriginalTaken is returned by SaveBatch when an original url of the batch is shortened already
+var ErrOriginalTaken = errors.New("original url is already shortened")
+
+// Storage keeps the short url -> original url mappings.
+// A cancelled ctx makes Save fail and Get/Exists report nothing found.
+type Storage interface {
+	Save(ctx context.Context, shortURL, originalURL string) error
+	Get(ctx context.Context, shortURL string) (string, bool)
+	// Lookup is Get telling an unknown short url (ErrNotFound) from a storage failure
+	Lookup(ctx context.Context, shortURL string) (string, error)
+	Exists(ctx context.Context, shortURL string) bool
+	// GetByOriginal finds the short url of an already shortened original url
+	GetByOriginal(ctx context.Context, originalURL string) (string, bool)
+	// GetOrCreate atomically returns the short url of an already shortened original
+	// with existed true or saves the original under the given short url
+	GetOrCreate(ctx context.Context, shortURL, originalURL string) (id string, existed bool, err error)
+	// SaveBatch saves all the new short urls or none of them, a taken short url
+	// fails it with ErrIDTaken and a shortened original url with ErrOriginalTaken
+	SaveBatch(ctx context.Context, records []models.URLRecord) error
+	Stats(ctx context.Context) (models.Stats, error)
+	// The weighted short url keeps its first target as the original url
+	SaveTargets(ctx context.Context, shortURL string, targets []models.Target) error
+	GetTargets(ctx context.Context, shortURL string) ([]models.Target, bool)
+	// Delete marks the short urls as deleted, Get still finds them so the
+	// handlers can answer 410, the original url can be shortened again
+	Delete(ctx context.Context, shortURLs []string) error
+	IsDeleted(ctx context.Context, shortURL string) bool
+	// CreatedAt is the time the short url was saved last
+	CreatedAt(ctx context.Context, shortURL string) (time.Time, bool)
+	// Export calls fn for every short url one by one, stopping at its error
+	Export(ctx context.Context, fn func(models.ExportedURL) error) error
+	// Import saves the exported url as it was, created at included, over a plain
+	// one, a weighted url fails with ErrIDTaken for a short url in use
+	Import(ctx context.Context, url models.ExportedURL) error
+	// NextSeq increments the counter of the ids and returns it, it never repeats
+	NextSeq(ctx context.Context) (uint64, error)
+	// Hit counts a resolution of the short url, Hits reports the count
+	Hit(ctx context.Context, shortURL string) error
+	Hits(ctx context.Context, shortURL string) int64
+}
+
+// Pinger is the storage behind a connection that can be lost, like the database
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ----------------------MemStorage----------------------------
+type MemStorage struct {
+	mu        sync.RWMutex
+	urls      map[string]string
+	originals map[string]string          // original url -> short url, the reverse of urls
+	targets   map[string][]models.Target // weighted short urls only
+	deleted   map[string]bool            // soft-deleted short urls
+	created   map[string]time.Time       // for the retention policy and the ttl
+	hits      map[string]int64           // the resolutions, not kept by the file storage
+	seq       uint64                     // the last NextSeq
+	ttl       time.Duration              // 0 - the urls don't expire
+	now       func() time.Time           // the clock, replaced in tests
+}
+
+// NewMemStorage starts with a copy of the urls, the given map isn't changed
+func NewMemStorage(urls map[string]string) *MemStorage {
+	copied := make(map[string]string, len(urls))
+	originals := make(map[string]string, len(urls))
+	created := make(map[string]time.Time, len(urls))
+	now := time.Now()
+	for shortURL, originalURL := range urls {
+		copied[shortURL] = originalURL
+		originals[originalURL] = shortURL
+		created[shortURL] = now
+	}
+	return &MemStorage{
+		urls:      copied,
+		originals: originals,
+		targets:   map[string][]models.Target{},
+		deleted:   map[string]bool{},
+		created:   created,
+		hits:      map[string]int64{},
+		now:       time.Now,
+	}
+}
+
+// SetTTL makes the urls older than ttl expire: they aren't found anymore and
+// Sweep purges them. 0 turns the expiration off.
+func (m *MemStorage) SetTTL(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ttl = ttl
+}
+
+// expired reports whether the short url outlived the ttl, m.mu must be held
+func (m *MemStorage) expired(shortURL string) bool {
+	created, ok := m.created[shortURL]
+	return m.ttl > 0 && ok && !m.now().Before(created.Add(m.ttl))
+}
+
+// dropExpired removes the short url if it expired, m.mu must be held for writing
+func (m *MemStorage) dropExpired(shortURL string) bool {
+	if !m.expired(shortURL) {
+		return false
+	}
+	m.remove(shortURL)
+	return true
+}
+
+func (m *MemStorage) Save(ctx context.Context, shortURL, originalURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.urls[shortURL]; ok && m.originals[old] == shortURL {
+		delete(m.originals, old) // keep both maps consistent on overwrite
+	}
+	m.urls[shortURL] = originalURL
+	m.originals[originalURL] = shortURL
+	m.created[shortURL] = m.now()
+	delete(m.deleted, shortURL)
+	delete(m.hits, shortURL) // the new url starts from zero
+	return nil
+}
+
+func (m *MemStorage) Get(ctx context.Context, shortURL string) (string, bool) {
+	if ctx.Err() != nil {
+		return "", false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	original, ok := m.urls[shortURL]
+	if !ok || m.expired(shortURL) {
+		return "", false
+	}
+	return original, true
+}
+
+func (m *MemStorage) Lookup(ctx context.Context, shortURL string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if original, ok := m.Get(ctx, shortURL); ok {
+		return original, nil
+	}
+	return "", ErrNotFound
+}
+
+func (m *MemStorage) Exists(ctx context.Context, shortURL string) bool {
+	_, ok := m.Get(ctx, shortURL)
+	return ok
+}
+
+func (m *MemStorage) GetByOriginal(ctx context.Context, originalURL string) (string, bool) {
+	if ctx.Err() != nil {
+		return "", false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	shortURL, ok := m.originals[originalURL]
+	if !ok || m.expired(shortURL) {
+		return "", false
+	}
+	return shortURL, true
+}
+
+func (m *MemStorage) GetOrCreate(ctx context.Context, shortURL, originalURL string) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.originals[originalURL]; ok && !m.dropExpired(existing) {
+		return existing, true, nil
+	}
+	if _, ok := m.urls[shortURL]; ok && !m.dropExpired(shortURL) {
+		return "", false, ErrIDTaken
+	}
+	m.urls[shortURL] = originalURL
+	m.originals[originalURL] = shortURL
+	m.created[shortURL] = m.now()
+	return shortURL, false, nil
+}
+
+// SaveBatch fails when a short url is taken or an original url is shortened already
+func (m *MemStorage) SaveBatch(ctx context.Context, records []models.URLRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkBatch(records); err != nil {
+		return err
+	}
+	now := m.now()
+	for _, record := range records {
+		m.dropExpired(record.ShortURL)
+		if shortURL, ok := m.originals[record.OriginalURL]; ok {
+			m.dropExpired(shortURL)
+		}
+		m.urls[record.ShortURL] = record.OriginalURL
+		m.originals[record.OriginalURL] = record.ShortURL
+		m.created[record.ShortURL] = now
+	}
+	return nil
+}
+
+// checkBatch finds the conflicts of the batch before anything is saved, m.mu must be held.
+// The expired urls don't conflict.
+func (m *MemStorage) checkBatch(records []models.URLRecord) error {
+	shortURLs := make(map[string]bool, len(records))
+	originals := make(map[string]bool, len(records))
+	for _, record := range records {
+		if _, ok := m.urls[record.ShortURL]; (ok && !m.expired(record.ShortURL)) || shortURLs[record.ShortURL] {
+			return ErrIDTaken
+		}
+		if shortURL, ok := m.originals[record.OriginalURL]; (ok && !m.expired(shortURL)) || originals[record.OriginalURL] {
+			return ErrOriginalTaken
+		}
+		shortURLs[record.ShortURL], originals[record.OriginalURL] = true, true
+	}
+	return nil
+}
+
+// Stats counts the urls not deleted and not expired
+func (m *MemStorage) Stats(ctx context.Context) (models.Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Stats{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var stats models.Stats
+	for shortURL := range m.urls {
+		if !m.deleted[shortURL] && !m.expired(shortURL) {
+			stats.URLs++
+		}
+	}
+	return stats, nil
+}
+
+// Export reads the urls one at a time, those saved meanwhile may be missed
+func (m *MemStorage) Export(ctx context.Context, fn func(models.ExportedURL) error) error {
+	m.mu.RLock()
+	shortURLs := make([]string, 0, len(m.urls))
+	for shortURL := range m.urls {
+		shortURLs = append(shortURLs, shortURL)
+	}
+	m.mu.RUnlock()
+	sort.Strings(shortURLs)
+
+	for _, shortURL := range shortURLs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m.mu.RLock()
+		original, ok := m.urls[shortURL]
+		url := models.ExportedURL{ID: shortURL, OriginalURL: original, Targets: m.targets[shortURL], Deleted: m.deleted[shortURL]}
+		if created, known := m.created[shortURL]; known {
+			url.CreatedAt = &created
+		}
+		ok = ok && !m.expired(shortURL)
+		m.mu.RUnlock()
+		if !ok {
+			continue // removed meanwhile
+		}
+		if err := fn(url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveTargets fails with ErrIDTaken for a short url in use
+func (m *MemStorage) SaveTargets(ctx context.Context, shortURL string, targets []models.Target) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return errNoTargets
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.urls[shortURL]; ok && !m.dropExpired(shortURL) {
+		return ErrIDTaken
+	}
+	m.putTargets(shortURL, targets)
+	return nil
+}
+
+// putTargets saves the weighted url over any, m.mu must be held for writing
+func (m *MemStorage) putTargets(shortURL string, targets []models.Target) {
+	m.urls[shortURL] = targets[0].URL
+	m.targets[shortURL] = targets
+	m.created[shortURL] = m.now()
+}
+
+func (m *MemStorage) GetTargets(ctx context.Context, shortURL string) ([]models.Target, bool) {
+	if ctx.Err() != nil {
+		return nil, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	targets, ok := m.targets[shortURL]
+	if !ok || m.expired(shortURL) {
+		return nil, false
+	}
+	return targets, true
+}
+
+func (m *MemStorage) Delete(ctx context.Context, shortURLs []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, shortURL := range shortURLs {
+		original, ok := m.urls[shortURL]
+		if !ok {
+			continue // unknown ids are skipped
+		}
+		m.deleted[shortURL] = true
+		if m.originals[original] == shortURL {
+			delete(m.originals, original)
+		}
+	}
+	return nil
+}
+
+func (m *MemStorage) IsDeleted(ctx context.Context, shortURL string) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.deleted[shortURL]
+}
+
+func (m *MemStorage) CreatedAt(ctx context.Context, shortURL string) (time.Time, bool) {
+	if ctx.Err() != nil {
+		return time.Time{}, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	created, ok := m.created[shortURL]
+	if !ok || m.expired(shortURL) {
+		return time.Time{}, false
+	}
+	return created, true
+}
+
+func (m *MemStorage) Hit(ctx context.Context, shortURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.urls[shortURL]; ok && !m.expired(shortURL) {
+		m.hits[shortURL]++
+	}
+	return nil
+}
+
+func (m *MemStorage) Hits(ctx context.Context, shortURL string) int64 {
+	if ctx.Err() != nil {
+		return 0
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.expired(shortURL) {
+		return 0
+	}
+	return m.hits[shortURL]
+}
+
+func (m *MemStorage) NextSeq(ctx context.Context) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	return m.seq, nil
+}
+
+// Put keeps the archived record as it was: created at, hit and deleted
+func (m *MemStorage) Put(ctx context.Context, record models.URLRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(record)
+	return nil
+}
+
+func (m *MemStorage) Import(ctx context.Context, url models.ExportedURL) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.urls[url.ID]; ok && len(url.Targets) > 0 && !m.dropExpired(url.ID) {
+		return ErrIDTaken
+	}
+	m.put(importedRecord(url))
+	return nil
+}
+
+// importedRecord is the record of the exported url, the weighted one keeps its first target
+func importedRecord(url models.ExportedURL) models.URLRecord {
+	record := models.URLRecord{ShortURL: url.ID, OriginalURL: url.OriginalURL, Targets: url.Targets, DeletedFlag: url.Deleted, CreatedAt: url.CreatedAt}
+	if len(url.Targets) > 0 {
+		record.OriginalURL = url.Targets[0].URL
+	}
+	return record
+}
+
+// put saves the record over any, without a creation time it is created now, m.mu must be held for writing
+func (m *MemStorage) put(record models.URLRecord) {
+	m.remove(record.ShortURL)
+	m.urls[record.ShortURL] = record.OriginalURL
+	if len(record.Targets) > 0 {
+		m.targets[record.ShortURL] = record.Targets
+	}
+	if record.DeletedFlag {
+		m.deleted[record.ShortURL] = true
+	} else if len(record.Targets) == 0 {
+		m.originals[record.OriginalURL] = record.ShortURL
+	}
+	m.created[record.ShortURL] = m.now()
+	if record.CreatedAt != nil {
+		m.created[record.ShortURL] = *record.CreatedAt
+	}
+	if record.Hits > 0 {
+		m.hits[record.ShortURL] = record.Hits
+	}
+}
+
+// restoreCreated sets the creation time kept by the file storage
+func (m *MemStorage) restoreCreated(shortURL string, created time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.urls[shortURL]; ok {
+		m.created[shortURL] = created
+	}
+}
+
+func (m *MemStorage) MoveBefore(ctx context.Context, t time.Time, put func(models.URLRecord) error) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.moveBefore(t, put, nil)
+}
+
+// moveBefore puts the urls created before t, the expired ones are only dropped,
+// dropped is called before a url is dropped, m.mu must be held for writing
+func (m *MemStorage) moveBefore(t time.Time, put, dropped func(models.URLRecord) error) (int, error) {
+	moved := 0
+	for shortURL, created := range m.created {
+		if !created.Before(t) {
+			continue
+		}
+		record := models.URLRecord{
+			ShortURL:    shortURL,
+			OriginalURL: m.urls[shortURL],
+			Targets:     m.targets[shortURL],
+			DeletedFlag: m.deleted[shortURL],
+			CreatedAt:   &created,
+			Hits:        m.hits[shortURL],
+		}
+		if !m.expired(shortURL) {
+			if err := put(record); err != nil {
+				return moved, err
+			}
+		}
+		if dropped != nil {
+			if err := dropped(record); err != nil {
+				return moved, err
+			}
+		}
+		m.remove(shortURL)
+		moved++
+	}
+	return moved, nil
+}
+
+// Remove drops the short urls completely, unlike Delete
+func (m *MemStorage) Remove(ctx context.Context, shortURLs []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, shortURL := range shortURLs {
+		m.remove(shortURL)
+	}
+	return nil
+}
+
+// remove drops the short url from all the maps, m.mu must be held for writing
+func (m *MemStorage) remove(shortURL string) {
+	if original, ok := m.urls[shortURL]; ok && m.originals[original] == shortURL {
+		delete(m.originals, original)
+	}
+	delete(m.urls, shortURL)
+	delete(m.targets, shortURL)
+	delete(m.deleted, shortURL)
+	delete(m.created, shortURL)
+	delete(m.hits, shortURL)
+}
+
+// Sweep purges the expired urls and returns their count, the reads skip them
+// already, so it only frees the memory
+func (m *MemStorage) Sweep(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	swept := 0
+	for shortURL := range m.created {
+		if m.dropExpired(shortURL) {
+			swept++
+		}
+	}
+	return swept, nil
+}
+
+// RunSweeper sweeps every interval until ctx is done
+func (m *MemStorage) RunSweeper(ctx context.Context, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			swept, err := m.Sweep(ctx)
+			if err != nil {
+				logger.Sugar().Errorw("Sweep failed", "Error", err)
+			} else if swept > 0 {
+				logger.Sugar().Infow("Expired urls purged", "Count", swept)
+			}
+		}
+	}
+}
+
+// ----------------------MemStorage----------------------------
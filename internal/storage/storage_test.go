@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/absurd678/skill/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MemStorage(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+
+	original, ok := store.Get(ctx, "sharaga")
+	require.True(t, ok)
+	require.Equal(t, "https://mai.ru", original)
+	require.False(t, store.Exists(ctx, "test"))
+
+	require.NoError(t, store.Save(ctx, "test", "https://practicum.net"))
+	require.True(t, store.Exists(ctx, "test"))
+	original, ok = store.Get(ctx, "test")
+	require.True(t, ok)
+	require.Equal(t, "https://practicum.net", original)
+}
+
+// The storage works on a copy, the given map stays as it was
+func Test_MemStorageCopiesURLs(t *testing.T) {
+	ctx := context.Background()
+	urls := map[string]string{"sharaga": "https://mai.ru"}
+	store := NewMemStorage(urls)
+
+	require.NoError(t, store.Save(ctx, "test", "https://practicum.net"))
+	require.NoError(t, store.Remove(ctx, []string{"sharaga"}))
+	require.Equal(t, map[string]string{"sharaga": "https://mai.ru"}, urls)
+}
+
+func Test_MemStorageStats(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+	require.NoError(t, store.Save(ctx, "test", "https://practicum.net"))
+	require.NoError(t, store.Save(ctx, "old", "https://practicum.net/old"))
+	require.NoError(t, store.Save(ctx, "expired", "https://practicum.net/expired"))
+	require.NoError(t, store.Delete(ctx, []string{"old"}))
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	store.SetTTL(time.Hour)
+	store.restoreCreated("expired", now.Add(-2*time.Hour))
+
+	stats, err := store.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, models.Stats{URLs: 2}, stats, "the deleted and the expired aren't counted")
+}
+
+func Test_MemStorageTargets(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemStorage(nil)
+	targets := []models.Target{{URL: "https://a.example", Weight: 1}, {URL: "https://b.example", Weight: 3}}
+
+	require.Error(t, store.SaveTargets(ctx, "ab", nil))
+	require.NoError(t, store.SaveTargets(ctx, "ab", targets))
+	require.ErrorIs(t, store.SaveTargets(ctx, "ab", targets[:1]), ErrIDTaken)
+	loaded, ok := store.GetTargets(ctx, "ab")
+	require.True(t, ok)
+	require.Equal(t, targets, loaded)
+
+	// the first target is the original url
+	original, ok := store.Get(ctx, "ab")
+	require.True(t, ok)
+	require.Equal(t, "https://a.example", original)
+}
+
+func Test_MemStorageReverseIndex(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+
+	shortURL, ok := store.GetByOriginal(ctx, "https://mai.ru")
+	require.True(t, ok)
+	require.Equal(t, "sharaga", shortURL)
+
+	// overwriting the id drops its old original
+	require.NoError(t, store.Save(ctx, "sharaga", "https://practicum.net"))
+	_, ok = store.GetByOriginal(ctx, "https://mai.ru")
+	require.False(t, ok)
+	shortURL, ok = store.GetByOriginal(ctx, "https://practicum.net")
+	require.True(t, ok)
+	require.Equal(t, "sharaga", shortURL)
+}
+
+func Test_MemStorageGetOrCreate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+
+	shortURL, existed, err := store.GetOrCreate(ctx, "test", "https://practicum.net")
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.Equal(t, "test", shortURL)
+
+	// the known original keeps its id whatever id is offered
+	shortURL, existed, err = store.GetOrCreate(ctx, "other", "https://mai.ru")
+	require.NoError(t, err)
+	require.True(t, existed)
+	require.Equal(t, "sharaga", shortURL)
+	require.False(t, store.Exists(ctx, "other"))
+
+	_, _, err = store.GetOrCreate(ctx, "sharaga", "https://example.com")
+	require.ErrorIs(t, err, ErrIDTaken)
+}
+
+func Test_MemStorageGetOrCreateConcurrent(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemStorage(nil)
+
+	const workers = 50
+	var wg sync.WaitGroup
+	created := make(chan string, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			shortURL, existed, err := store.GetOrCreate(ctx, strconv.Itoa(i), "https://practicum.net")
+			if assert.NoError(t, err) && !existed {
+				created <- shortURL
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(created)
+
+	require.Len(t, created, 1)
+	stats, err := store.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.URLs)
+}
+
+func Test_MemStorageHits(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+
+	const workers = 50
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, store.Hit(ctx, "sharaga"))
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int64(workers), store.Hits(ctx, "sharaga"))
+
+	// an unknown id isn't counted, a saved again one starts from zero
+	require.NoError(t, store.Hit(ctx, "unknown"))
+	require.Zero(t, store.Hits(ctx, "unknown"))
+	require.NoError(t, store.Save(ctx, "sharaga", "https://practicum.net"))
+	require.Zero(t, store.Hits(ctx, "sharaga"))
+}
+
+func Test_MemStorageDelete(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+
+	require.NoError(t, store.Delete(ctx, []string{"sharaga", "unknown"}))
+	require.True(t, store.IsDeleted(ctx, "sharaga"))
+	require.False(t, store.IsDeleted(ctx, "unknown"))
+
+	// the deleted id is still found but isn't given out again
+	original, ok := store.Get(ctx, "sharaga")
+	require.True(t, ok)
+	require.Equal(t, "https://mai.ru", original)
+	_, ok = store.GetByOriginal(ctx, "https://mai.ru")
+	require.False(t, ok)
+	_, _, err := store.GetOrCreate(ctx, "sharaga", "https://practicum.net")
+	require.ErrorIs(t, err, ErrIDTaken)
+}
+
+func Test_MemStorageSaveBatch(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemStorage(map[string]string{"sharaga": "https://mai.ru"})
+
+	require.NoError(t, store.SaveBatch(ctx, []models.URLRecord{
+		{ShortURL: "a", OriginalURL: "https://a.example"},
+		{ShortURL: "b", OriginalURL: "https://b.example"},
+	}))
+	shortURL, ok := store.GetByOriginal(ctx, "https://b.example")
+	require.True(t, ok)
+	require.Equal(t, "b", shortURL)
+
+	// a conflict anywhere saves nothing
+	require.ErrorIs(t, store.SaveBatch(ctx, []models.URLRecord{
+		{ShortURL: "c", OriginalURL: "https://c.example"},
+		{ShortURL: "sharaga", OriginalURL: "https://d.example"},
+	}), ErrIDTaken)
+	require.Error(t, store.SaveBatch(ctx, []models.URLRecord{
+		{ShortURL: "c", OriginalURL: "https://c.example"},
+		{ShortURL: "d", OriginalURL: "https://c.example"},
+	}))
+	require.False(t, store.Exists(ctx, "c"))
+}
+
+// The export skips the expired urls and stops at the callback error
+func Test_MemStorageExport(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemStorage(nil)
+	store.now = func() time.Time { return now }
+	store.SetTTL(time.Minute)
+	require.NoError(t, store.Save(ctx, "old", "https://practicum.net"))
+	now = now.Add(time.Minute)
+	require.NoError(t, store.Save(ctx, "sharaga", "https://mai.ru"))
+	require.NoError(t, store.Save(ctx, "api", "https://practicum.net/api"))
+
+	var ids []string
+	require.NoError(t, store.Export(ctx, func(url models.ExportedURL) error {
+		ids = append(ids, url.ID)
+		return nil
+	}))
+	require.Equal(t, []string{"api", "sharaga"}, ids)
+
+	errStop := errors.New("stop")
+	ids = nil
+	require.ErrorIs(t, store.Export(ctx, func(url models.ExportedURL) error {
+		ids = append(ids, url.ID)
+		return errStop
+	}), errStop)
+	require.Equal(t, []string{"api"}, ids)
+}
+
+func Test_MemStorageTTL(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemStorage(nil)
+	store.now = func() time.Time { return now }
+	store.SetTTL(time.Minute)
+
+	require.NoError(t, store.Save(ctx, "sharaga", "https://mai.ru"))
+	targets := []models.Target{{URL: "https://a.example", Weight: 1}}
+	require.NoError(t, store.SaveTargets(ctx, "ab", targets))
+	now = now.Add(30 * time.Second)
+	require.NoError(t, store.Save(ctx, "fresh", "https://practicum.net"))
+
+	// before the ttl everything is found
+	require.True(t, store.Exists(ctx, "sharaga"))
+	_, ok := store.GetTargets(ctx, "ab")
+	require.True(t, ok)
+
+	now = now.Add(30 * time.Second) // sharaga and ab are a minute old
+	_, ok = store.Get(ctx, "sharaga")
+	require.False(t, ok)
+	_, ok = store.GetByOriginal(ctx, "https://mai.ru")
+	require.False(t, ok)
+	_, ok = store.GetTargets(ctx, "ab")
+	require.False(t, ok)
+	original, ok := store.Get(ctx, "fresh")
+	require.True(t, ok)
+	require.Equal(t, "https://practicum.net", original)
+
+	// the expired original and id are given out again
+	shortURL, existed, err := store.GetOrCreate(ctx, "sharaga", "https://mai.ru")
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.Equal(t, "sharaga", shortURL)
+	require.NoError(t, store.SaveBatch(ctx, []models.URLRecord{{ShortURL: "ab", OriginalURL: "https://ilovespb.ru"}}))
+	_, ok = store.GetTargets(ctx, "ab")
+	require.False(t, ok, "the targets of the expired url are kept")
+
+	// turned off, nothing expires
+	store.SetTTL(0)
+	now = now.Add(time.Hour)
+	require.True(t, store.Exists(ctx, "fresh"))
+}
+
+func Test_MemStorageSweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemStorage(nil)
+	store.now = func() time.Time { return now }
+	store.SetTTL(time.Minute)
+
+	require.NoError(t, store.Save(ctx, "sharaga", "https://mai.ru"))
+	require.NoError(t, store.Save(ctx, "old", "https://example.com"))
+	require.NoError(t, store.Delete(ctx, []string{"old"}))
+	now = now.Add(time.Minute)
+	require.NoError(t, store.Save(ctx, "fresh", "https://practicum.net"))
+
+	swept, err := store.Sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, swept)
+	stats, err := store.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.URLs)
+	require.NotContains(t, store.originals, "https://mai.ru")
+	require.NotContains(t, store.deleted, "old")
+
+	swept, err = store.Sweep(ctx)
+	require.NoError(t, err)
+	require.Zero(t, swept)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = store.Sweep(cancelled)
+	require.ErrorIs(t, err, context.Canceled)
+}